@@ -0,0 +1,7 @@
+package main
+
+import "pkg/changepoint"
+
+func main() {
+	changepoint.Start()
+}
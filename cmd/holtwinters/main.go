@@ -0,0 +1,7 @@
+package main
+
+import "pkg/holtwinters"
+
+func main() {
+	holtwinters.Start()
+}
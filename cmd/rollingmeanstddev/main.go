@@ -0,0 +1,7 @@
+package main
+
+import "pkg/rollingmeanstddev"
+
+func main() {
+	rollingmeanstddev.Start()
+}
@@ -0,0 +1,116 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+
+	"pkg/fieldstats"
+)
+
+// What EndBatch sends: the summary point, the points of the batch
+// annotated with their z-scores, or both.
+const (
+	emitSummary = "summary"
+	emitPoints  = "points"
+	emitBoth    = "both"
+)
+
+// How the points are scored: relative to the mean and stddev, or to the
+// median and scaled MAD which a few outliers do not drag along.
+const (
+	zscoreStandard = "standard"
+	zscoreRobust   = "robust"
+)
+
+func parseEmit(emit string) (string, error) {
+	switch emit = strings.ToLower(strings.TrimSpace(emit)); emit {
+	case "":
+		return emitSummary, nil
+	case emitSummary, emitPoints, emitBoth:
+		return emit, nil
+	}
+
+	return "", fmt.Errorf("invalid 'emit' value '%s', must be 'summary', 'points' or 'both'", emit)
+}
+
+func parseZScore(zscore string) (string, error) {
+	switch zscore = strings.ToLower(strings.TrimSpace(zscore)); zscore {
+	case "":
+		return zscoreStandard, nil
+	case zscoreStandard, zscoreRobust:
+		return zscore, nil
+	}
+
+	return "", fmt.Errorf("invalid 'zscore' value '%s', must be 'standard' or 'robust'", zscore)
+}
+
+// scale is the center and spread of a field the points are scored by.
+type scale struct {
+	center float64
+	spread float64
+	ok     bool
+}
+
+// scales returns the mean and stddev of each field of the batch, or the
+// median and scaled MAD for robust z-scores.
+func (sm *calcMeanStddev) scales() map[string]scale {
+	res := make(map[string]scale, len(sm.total.series))
+	for field, s := range sm.total.series {
+		if sm.zscore == zscoreRobust {
+			sort.Float64s(s.values)
+			smad, _ := robustStatistic("smad", s.values, sm.ddof)
+			res[field] = scale{center: percentile(s.values, 50), spread: smad, ok: true}
+			continue
+		}
+
+		sd, ok := s.acc.Stddev(sm.ddof)
+		res[field] = scale{center: s.acc.Mean(), spread: sd, ok: ok}
+	}
+
+	return res
+}
+
+// annotatePoint adds the fields "zscore", "deviation" and "is_outlier" of
+// each field of the point p, relative to the scales of the batch. The
+// z-score is left out if the spread is 0.
+func (sm *calcMeanStddev) annotatePoint(p *agent.Point, scales map[string]scale) {
+	if p.FieldsDouble == nil {
+		p.FieldsDouble = make(map[string]float64)
+	}
+	if p.FieldsBool == nil {
+		p.FieldsBool = make(map[string]bool)
+	}
+
+	for field, val := range sm.fields.Values(p) {
+		sc, ok := scales[field]
+		if !ok {
+			continue
+		}
+
+		deviation := val - sc.center
+		isOutlier := false
+
+		p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, "deviation")] = deviation
+		if sc.ok && sc.spread > 0 && !math.IsNaN(sc.spread) {
+			z := deviation / sc.spread
+			p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, "zscore")] = z
+			isOutlier = math.Abs(z) >= sm.threshold
+		}
+		p.FieldsBool[fieldstats.OutputFieldName(sm.fieldFormat, field, "is_outlier")] = isOutlier
+	}
+}
+
+// sendAnnotatedBatch sends the points of the batch annotated with their
+// z-scores, framed by the begin and end of the batch.
+func (sm *calcMeanStddev) sendAnnotatedBatch(end *agent.EndBatch) {
+	scales := sm.scales()
+	for _, p := range sm.points {
+		sm.annotatePoint(p, scales)
+	}
+
+	sm.sendBatch(end, sm.points)
+}
@@ -0,0 +1,82 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestEndBatchEmitPoints(t *testing.T) {
+	for _, tc := range [...]struct {
+		emit      string
+		responses int
+	}{
+		{"points", 10},
+		{"both", 13},
+	} {
+		t.Run(fmt.Sprintf("Emit %s", tc.emit), func(t *testing.T) {
+			ch := make(chan *agent.Response, 13)
+			sm := newCalcMeanStddev(&agent.Agent{Responses: ch})
+			initHandler(t, sm,
+				stringOption("field", "cpu"),
+				stringOption("emit", tc.emit),
+				&agent.Option{
+					Name: "threshold",
+					Values: []*agent.OptionValue{
+						{Type: agent.ValueType_DOUBLE, Value: &agent.OptionValue_DoubleValue{DoubleValue: 1.5}},
+					},
+				})
+
+			// Mean 5 and stddev 2
+			values := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+			sm.BeginBatch(&agent.BeginBatch{Name: "cpu"})
+			for _, v := range values {
+				sm.Point(&agent.Point{FieldsDouble: map[string]float64{"cpu": v}})
+			}
+			sm.EndBatch(&agent.EndBatch{Name: "cpu", Group: "host=a"})
+
+			if len(ch) != tc.responses {
+				t.Fatalf("expected %d responses, actual %d", tc.responses, len(ch))
+			}
+
+			begin, ok := (<-ch).Message.(*agent.Response_Begin)
+			if !ok || begin.Begin.Size != 8 || begin.Begin.Group != "host=a" {
+				t.Fatalf("expected begin of batch of 8 points, actual %v", begin)
+			}
+			for _, v := range values {
+				p := (<-ch).Message.(*agent.Response_Point).Point
+				if p.FieldsDouble["deviation"] != v-5 || math.Abs(p.FieldsDouble["zscore"]-(v-5)/2) > 1e-12 ||
+					p.FieldsBool["is_outlier"] != (v == 2 || v == 9) {
+					t.Errorf("unexpected annotation of %v: %v %v", v, p.FieldsDouble, p.FieldsBool)
+				}
+			}
+			if _, ok := (<-ch).Message.(*agent.Response_End); !ok {
+				t.Errorf("expected end of batch")
+			}
+
+			if tc.emit == "both" {
+				// The summary is framed by a batch of its own
+				begin, ok := (<-ch).Message.(*agent.Response_Begin)
+				if !ok || begin.Begin.Size != 1 {
+					t.Fatalf("expected begin of batch of the summary, actual %v", begin)
+				}
+				p := (<-ch).Message.(*agent.Response_Point).Point
+				if p.FieldsDouble["mean"] != 5 || p.FieldsDouble["stddev"] != 2 {
+					t.Errorf("unexpected summary %v", p.FieldsDouble)
+				}
+				if _, ok := (<-ch).Message.(*agent.Response_End); !ok {
+					t.Errorf("expected end of batch of the summary")
+				}
+			}
+		})
+	}
+}
+
+func TestInitInvalidEmit(t *testing.T) {
+	sm := newCalcMeanStddev(&agent.Agent{})
+	if init, _ := sm.Init(newInitRequest(stringOption("field", "cpu"), stringOption("emit", "all"))); init.Success {
+		t.Errorf("expected failure, actual success")
+	}
+}
@@ -0,0 +1,145 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"pkg/fieldstats"
+	"pkg/matchtime"
+)
+
+// baseline compares the current period of a batch with the same time in
+// the historical periods, e.g. this hour against the same hour on the
+// previous weeks. The periods are defined by time mask templates whose
+// "now" is the end of the batch, like "W==now & h==now & D==now" for the
+// current period and "W==now & h==now & D!=now" for the history.
+type baseline struct {
+	current   string
+	history   string
+	threshold float64
+
+	// The points of the batch, as the masks are only known at the end
+	entries []baselineEntry
+}
+
+type baselineEntry struct {
+	dt     time.Time
+	values map[string]float64
+}
+
+// baselineResult is the comparison for a field. The baseline mean and
+// stddev are of the means of the historical periods, one per day.
+type baselineResult struct {
+	current    float64
+	hasCurrent bool
+
+	mean      float64
+	stddev    float64
+	hasStddev bool
+	periods   int64
+
+	zscore  float64
+	hasZ    bool
+	anomaly bool
+}
+
+func newBaseline(current string, history string, threshold float64) (*baseline, error) {
+	if len(current) == 0 && len(history) == 0 {
+		return nil, nil
+	}
+
+	if len(current) == 0 || len(history) == 0 {
+		return nil, fmt.Errorf("must supply both current and history masks for 'baseline'")
+	}
+	return &baseline{
+		current:   current,
+		history:   history,
+		threshold: threshold,
+	}, nil
+}
+
+func (b *baseline) reset() {
+	b.entries = nil
+}
+
+func (b *baseline) add(dt time.Time, values map[string]float64) {
+	b.entries = append(b.entries, baselineEntry{dt: dt, values: values})
+}
+
+// calculate compares the current period with the history, given the end of
+// the batch in the time zone of the handler.
+func (b *baseline) calculate(end time.Time, ddof int64) map[string]*baselineResult {
+	currentMask := substituteNow(b.current, &end)
+	historyMask := substituteNow(b.history, &end)
+
+	current := make(map[string]*fieldstats.Accumulator)
+	history := make(map[string]map[string]*fieldstats.Accumulator) // by field and day
+
+	for _, e := range b.entries {
+		if matchtime.MatchTimeWithMask(currentMask, &e.dt) {
+			for name, v := range e.values {
+				accumulatorOf(current, name).Add(v)
+			}
+		} else if matchtime.MatchTimeWithMask(historyMask, &e.dt) {
+			day := e.dt.Format("2006-01-02")
+			for name, v := range e.values {
+				if history[name] == nil {
+					history[name] = make(map[string]*fieldstats.Accumulator)
+				}
+				accumulatorOf(history[name], day).Add(v)
+			}
+		}
+	}
+
+	res := make(map[string]*baselineResult)
+	for name, acc := range current {
+		r := &baselineResult{current: acc.Mean(), hasCurrent: true}
+		res[name] = r
+		b.compare(r, history[name], ddof)
+	}
+	for name, periods := range history {
+		if _, ok := res[name]; !ok {
+			r := &baselineResult{}
+			res[name] = r
+			b.compare(r, periods, ddof)
+		}
+	}
+
+	return res
+}
+
+func (b *baseline) compare(r *baselineResult, periods map[string]*fieldstats.Accumulator, ddof int64) {
+	// Add the periods in order, so the result doesn't depend on the map order
+	days := make([]string, 0, len(periods))
+	for day := range periods {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	var acc fieldstats.Accumulator
+	for _, day := range days {
+		acc.Add(periods[day].Mean())
+	}
+
+	r.periods = acc.Count()
+	r.mean = acc.Mean()
+	r.stddev, r.hasStddev = acc.Stddev(ddof)
+
+	if r.hasCurrent && r.hasStddev && r.stddev > 0 && !math.IsNaN(r.stddev) {
+		r.zscore = (r.current - r.mean) / r.stddev
+		r.hasZ = true
+		r.anomaly = math.Abs(r.zscore) >= b.threshold
+	}
+}
+
+func accumulatorOf(m map[string]*fieldstats.Accumulator, key string) *fieldstats.Accumulator {
+	acc, ok := m[key]
+	if !ok {
+		acc = &fieldstats.Accumulator{}
+		m[key] = acc
+	}
+
+	return acc
+}
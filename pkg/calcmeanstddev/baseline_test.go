@@ -0,0 +1,85 @@
+package calcmeanstddev
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestBaselineCalculate(t *testing.T) {
+	b, err := newBaseline("W==now & h==now & D==now", "W==now & h==now & D!=now", 3)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The batch ends on Monday 2019-08-26 15:30
+	end := time.Date(2019, 8, 26, 15, 30, 0, 0, time.UTC)
+	add := func(offset time.Duration, v float64) {
+		b.add(end.Add(offset), map[string]float64{"cpu": v})
+	}
+
+	add(-20*time.Minute, 20)
+	add(-10*time.Minute, 22)
+	for week, v := range []float64{10, 12, 14} {
+		offset := -time.Duration(week+1) * 7 * 24 * time.Hour
+		add(offset-20*time.Minute, v-1)
+		add(offset-10*time.Minute, v+1)
+	}
+	add(-24*time.Hour, 100) // Sunday
+	add(-2*time.Hour, 100)  // another hour
+	add(-7*24*time.Hour-time.Hour, 100)
+
+	r := b.calculate(end, 0)["cpu"]
+	if r == nil {
+		t.Fatalf("expected result for 'cpu'")
+	}
+
+	stddev := math.Sqrt(8.0 / 3)
+	if r.current != 21 || r.mean != 12 || r.periods != 3 || math.Abs(r.stddev-stddev) > 1e-12 {
+		t.Errorf("expected current 21, mean 12, periods 3 and stddev %v, actual %v, %v, %v and %v",
+			stddev, r.current, r.mean, r.periods, r.stddev)
+	}
+	if math.Abs(r.zscore-9/stddev) > 1e-12 || !r.anomaly {
+		t.Errorf("expected zscore %v and anomaly, actual %v and %v", 9/stddev, r.zscore, r.anomaly)
+	}
+}
+
+func TestEndBatchBaseline(t *testing.T) {
+	sm := newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+	initHandler(t, sm,
+		stringOption("field", "cpu"),
+		&agent.Option{
+			Name: "baseline",
+			Values: []*agent.OptionValue{
+				stringValue("h==now & D==now"),
+				stringValue("h==now & D!=now"),
+			},
+		})
+
+	// The batch of runBatch ends at 2019-08-26 03:15
+	day := 24 * time.Hour
+	tmax := time.Date(2019, 8, 26, 3, 15, 0, 0, time.UTC)
+	points := []*agent.Point{
+		{Time: tmax.Add(-time.Minute).UnixNano(), FieldsDouble: map[string]float64{"cpu": 5}},
+		{Time: tmax.Add(-day).UnixNano(), FieldsDouble: map[string]float64{"cpu": 4}},
+		{Time: tmax.Add(-2 * day).UnixNano(), FieldsDouble: map[string]float64{"cpu": 6}},
+	}
+
+	actual := runBatch(t, sm, points)
+	if actual.FieldsDouble["current"] != 5 || actual.FieldsDouble["baseline_mean"] != 5 ||
+		actual.FieldsDouble["baseline_stddev"] != 1 || actual.FieldsDouble["zscore"] != 0 ||
+		actual.FieldsInt["baseline_periods"] != 2 || actual.FieldsBool["anomaly"] {
+		t.Errorf("unexpected baseline %v %v %v", actual.FieldsDouble, actual.FieldsInt, actual.FieldsBool)
+	}
+}
+
+func TestNewBaselineInvalid(t *testing.T) {
+	if _, err := newBaseline("h==now", "", 3); err == nil {
+		t.Errorf("expected error without history mask")
+	}
+	if b, err := newBaseline("", "", 3); b != nil || err != nil {
+		t.Errorf("expected no baseline, actual %v and %v", b, err)
+	}
+}
@@ -1,6 +1,8 @@
 package calcmeanstddev
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"math"
 	"os"
@@ -11,14 +13,70 @@ import (
 
 	"github.com/influxdata/kapacitor/udf/agent"
 
+	"pkg/fieldstats"
 	"pkg/matchtime"
+	"pkg/timesource"
+	"pkg/timezone"
+	"pkg/utils"
 )
 
 type calcMeanStddev struct {
-	timeFilter string
-	timeZone   string
-	field      string
-	entries    []float64
+	timeFilter  string
+	timeZone    *timezone.Zone
+	timeSource  *timesource.Source
+	fields      *fieldstats.Selector
+	fieldFormat string
+	stats       []string
+	ddof        int64
+
+	// What's accumulated in the batch, and in its sub-groups by the tags
+	// if supplied
+	total      *accumulation
+	by         []string
+	byTotal    bool
+	subgroups  map[string]*subgroup
+	keepValues bool
+	missing    string
+	minCount   int64
+
+	// Time-weighted statistics if asked for
+	weighting *timeWeighting
+
+	// The relation of the pairs of fields if asked for
+	correlation *correlation
+
+	// The least-squares line against time if asked for
+	withTrend bool
+	trendOpts trendOptions
+
+	// Exponentially weighted baselines carried across batches per group
+	ewma *ewma
+
+	// The distribution of the batch if asked for
+	histogram *histogram
+
+	// Compare the current period with the history instead if supplied
+	baseline  *baseline
+	threshold float64
+
+	// How the summary points are shaped, given the last point of the
+	// batch
+	shape shape
+	last  *agent.Point
+
+	// The points of the batch kept to be annotated, unless only the
+	// summary is sent, with the standard or robust z-scores
+	emit   string
+	zscore string
+	points []*agent.Point
+
+	// The "now" of the time filter is the wall clock, or the Tmax or
+	// latest point time of the batch. For the latter, the time mask is
+	// only known at the end, so the points are kept till then.
+	reference string
+	clock     func() time.Time
+	pending   []pendingPoint
+	latest    int64
 
 	timeMask string
 	now      time.Time
@@ -26,9 +84,28 @@ type calcMeanStddev struct {
 	agent *agent.Agent
 }
 
+// pendingPoint is a point waiting for the time mask of the batch.
+type pendingPoint struct {
+	t           int64
+	dt          time.Time
+	unknownZone bool
+	values      map[string]float64
+	missing     []string
+	pairValues  map[string]float64
+	subgroup    *subgroup
+}
+
+// References for the "now" of the time filter
+const (
+	referenceWall   = "wall"
+	referenceTmax   = "tmax"
+	referenceLatest = "latest"
+)
+
 func newCalcMeanStddev(agent *agent.Agent) *calcMeanStddev {
 	return &calcMeanStddev{
 		agent: agent,
+		clock: time.Now,
 	}
 }
 
@@ -39,8 +116,42 @@ func (*calcMeanStddev) Info() (*agent.InfoResponse, error) {
 		Provides: agent.EdgeType_BATCH,
 
 		Options: map[string]*agent.OptionInfo{
-			"timeFilter": {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_STRING}},
-			"field":      {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"timeFilter":       {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_STRING}},
+			"defaultTimeZone":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"unknownTimeZone":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"timeSource":       {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"timeSourceFormat": {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"timeSourceError":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"field":            {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"fieldFormat":      {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"stats":            {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"ddof":             {ValueTypes: []agent.ValueType{agent.ValueType_INT}},
+			"missing":          {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"by":               {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"correlate":        {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"as":               {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"prefix":           {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"tags":             {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"copyTags":         {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"copyFields":       {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"emptyBatch":       {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"emptyValue":       {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+			"byTotal":          {ValueTypes: []agent.ValueType{agent.ValueType_BOOL}},
+			"minCount":         {ValueTypes: []agent.ValueType{agent.ValueType_INT}},
+			"baseline":         {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_STRING}},
+			"histogram":        {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_STRING}},
+			"histogramOutput":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"threshold":        {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+			"emit":             {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"zscore":           {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"reference":        {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"interpolation":    {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"trendUnit":        {ValueTypes: []agent.ValueType{agent.ValueType_DURATION}},
+			"horizon":          {ValueTypes: []agent.ValueType{agent.ValueType_DURATION}},
+			"trendThreshold":   {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+			"alpha":            {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+			"halfLife":         {ValueTypes: []agent.ValueType{agent.ValueType_DURATION}},
+			"period":           {ValueTypes: []agent.ValueType{agent.ValueType_DURATION}},
 		},
 	}
 
@@ -54,31 +165,369 @@ func (sm *calcMeanStddev) Init(r *agent.InitRequest) (*agent.InitResponse, error
 		Error:   "",
 	}
 
+	var err error
+	var unknownTimeZone timezone.Policy
+	var timeSourceError timesource.Policy
+	timeZone, defaultTimeZone := "", ""
+	timeSource, timeSourceFormat := "", ""
+	var fields, stats, correlate []string
+	baselineCurrent, baselineHistory := "", ""
+	emit, zscore, interpolation, missing := "", "", "", ""
+	histogramKind, histogramSpec, histogramOutput := "", "", ""
+	emptyBatch := ""
+	sm.shape = shape{tags: make(map[string]string), emptyValue: -1}
+	var period, halfLife int64
+	var alpha float64
+	sm.threshold = 3.0
+
 	for _, opt := range r.Options {
 		switch opt.Name {
 		case "timeFilter":
 			sm.timeFilter = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
-			sm.timeZone = strings.TrimSpace(opt.Values[1].Value.(*agent.OptionValue_StringValue).StringValue)
+			timeZone = strings.TrimSpace(opt.Values[1].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "defaultTimeZone":
+			defaultTimeZone = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "unknownTimeZone":
+			unknownTimeZone, err = timezone.ParsePolicy(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "timeSource":
+			timeSource = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "timeSourceFormat":
+			timeSourceFormat = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "timeSourceError":
+			timeSourceError, err = timesource.ParsePolicy(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
 		case "field":
-			sm.field = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+			// Either a list of fields or repeated options
+			fields = append(fields, utils.SplitList(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)...)
+		case "fieldFormat":
+			sm.fieldFormat = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "stats":
+			stats = utils.SplitList(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "ddof":
+			sm.ddof = opt.Values[0].Value.(*agent.OptionValue_IntValue).IntValue
+		case "missing":
+			missing = opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue
+		case "as":
+			sm.shape.name = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "prefix":
+			sm.shape.prefix = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "tags":
+			err = parseStaticTags(sm.shape.tags, opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "copyTags":
+			sm.shape.copyTags = append(sm.shape.copyTags, utils.SplitList(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)...)
+		case "copyFields":
+			sm.shape.copyFields = append(sm.shape.copyFields, utils.SplitList(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)...)
+		case "emptyBatch":
+			emptyBatch = opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue
+		case "emptyValue":
+			sm.shape.emptyValue = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		case "correlate":
+			correlate = append(correlate, utils.SplitList(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)...)
+		case "by":
+			sm.by = append(sm.by, utils.SplitList(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)...)
+		case "byTotal":
+			sm.byTotal = opt.Values[0].Value.(*agent.OptionValue_BoolValue).BoolValue
+		case "minCount":
+			sm.minCount = opt.Values[0].Value.(*agent.OptionValue_IntValue).IntValue
+		case "baseline":
+			baselineCurrent = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+			baselineHistory = strings.TrimSpace(opt.Values[1].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "histogram":
+			histogramKind = opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue
+			histogramSpec = opt.Values[1].Value.(*agent.OptionValue_StringValue).StringValue
+		case "histogramOutput":
+			histogramOutput = opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue
+		case "threshold":
+			sm.threshold = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		case "emit":
+			emit = opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue
+		case "zscore":
+			zscore = opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue
+		case "interpolation":
+			interpolation = opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue
+		case "period":
+			period = opt.Values[0].Value.(*agent.OptionValue_DurationValue).DurationValue
+		case "trendUnit":
+			sm.trendOpts.unit = opt.Values[0].Value.(*agent.OptionValue_DurationValue).DurationValue
+		case "horizon":
+			sm.trendOpts.horizon = opt.Values[0].Value.(*agent.OptionValue_DurationValue).DurationValue
+		case "trendThreshold":
+			sm.trendOpts.threshold = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+			sm.trendOpts.hasThreshold = true
+		case "alpha":
+			alpha = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		case "halfLife":
+			halfLife = opt.Values[0].Value.(*agent.OptionValue_DurationValue).DurationValue
+		case "reference":
+			sm.reference = strings.ToLower(strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue))
+		}
+
+		if err != nil {
+			init.Success = false
+			init.Error = err.Error()
+			return init, nil
+		}
+	}
+
+	if sm.correlation, err = newCorrelation(correlate); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	// The fields are optional if only their relation is asked for
+	if len(fields) == 0 && sm.correlation != nil {
+		sm.fields = fieldstats.NewNamesSelector(nil)
+	} else if sm.fields, err = fieldstats.NewSelector(fields); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	// Keep the output fields "mean" and "stddev" for a single field, or
+	// "pearson" and "spearman" for a single pair, otherwise name them
+	// like "cpu_mean" and "cpu_stddev"
+	if len(sm.fieldFormat) == 0 {
+		sm.fieldFormat = "{field}_{stat}"
+		if sm.correlation == nil && sm.fields.IsSingle() ||
+			len(fields) == 0 && sm.correlation != nil && len(sm.correlation.pairs) == 1 {
+			sm.fieldFormat = "{stat}"
+		}
+	}
+
+	if sm.stats, err = parseStats(stats); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+	sm.keepValues = needsValues(sm.stats)
+
+	if sm.withTrend = needsTrend(sm.stats); sm.withTrend {
+		if sm.trendOpts.unit == 0 {
+			sm.trendOpts.unit = int64(time.Second)
+		}
+		if sm.trendOpts.unit < 0 {
+			init.Success = false
+			init.Error = "'trendUnit' must be positive"
+			return init, nil
+		}
+		for _, name := range sm.stats {
+			if name == "forecast" && sm.trendOpts.horizon <= 0 {
+				init.Success = false
+				init.Error = "must supply a positive 'horizon' for 'forecast'"
+				return init, nil
+			}
+			if name == "time_to_threshold" && !sm.trendOpts.hasThreshold {
+				init.Success = false
+				init.Error = "must supply 'trendThreshold' for 'time_to_threshold'"
+				return init, nil
+			}
+		}
+	}
+
+	if sm.weighting, err = newTimeWeighting(sm.stats, interpolation, period); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	if sm.ddof < 0 {
+		init.Success = false
+		init.Error = "'ddof' must not be negative"
+		return init, nil
+	}
+
+	if sm.missing, err = parseMissing(missing); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	if sm.minCount < 0 {
+		init.Success = false
+		init.Error = "'minCount' must not be negative"
+		return init, nil
+	}
+
+	if sm.threshold <= 0 {
+		init.Success = false
+		init.Error = "'threshold' must be positive"
+		return init, nil
+	}
+
+	if sm.baseline, err = newBaseline(baselineCurrent, baselineHistory, sm.threshold); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	if sm.emit, err = parseEmit(emit); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	if sm.zscore, err = parseZScore(zscore); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+	if sm.zscore == zscoreRobust && sm.emit != emitSummary {
+		sm.keepValues = true
+	}
+
+	if sm.shape.emptyBatch, err = parseEmptyBatch(emptyBatch); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	if sm.correlation != nil && sm.baseline != nil {
+		init.Success = false
+		init.Error = "cannot supply 'correlate' in 'baseline' mode"
+		return init, nil
+	}
+
+	switch sm.reference {
+	case "":
+		sm.reference = referenceWall
+	case referenceWall, referenceTmax, referenceLatest:
+	default:
+		init.Success = false
+		init.Error = fmt.Sprintf("invalid 'reference' '%s', must be 'wall', 'tmax' or 'latest'", sm.reference)
+		return init, nil
+	}
+
+	if sm.baseline != nil && sm.emit != emitSummary {
+		init.Success = false
+		init.Error = "cannot 'emit' points in 'baseline' mode"
+		return init, nil
+	}
+
+	if sm.ewma, err = newEWMA(alpha, halfLife); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+	if sm.ewma != nil && sm.baseline != nil {
+		init.Success = false
+		init.Error = "cannot supply 'alpha' or 'halfLife' in 'baseline' mode"
+		return init, nil
+	}
+
+	if sm.histogram, err = newHistogram(histogramKind, histogramSpec, histogramOutput); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+	if sm.histogram != nil {
+		if sm.baseline != nil {
+			init.Success = false
+			init.Error = "cannot supply 'histogram' in 'baseline' mode"
+			return init, nil
+		}
+		if sm.histogram.output == histogramPoints && sm.emit != emitSummary {
+			init.Success = false
+			init.Error = "cannot 'emit' points with 'histogram' points"
+			return init, nil
+		}
+	}
+
+	// The histogram must be built to tell its output
+	if len(sm.by) > 0 {
+		switch {
+		case sm.baseline != nil:
+			init.Error = "cannot supply 'by' in 'baseline' mode"
+		case sm.emit != emitSummary:
+			init.Error = "cannot 'emit' points with 'by'"
+		case sm.histogram != nil && sm.histogram.output == histogramPoints:
+			init.Error = "cannot supply 'by' with 'histogram' points"
+		}
+		if init.Error != "" {
+			init.Success = false
+			return init, nil
+		}
+	}
+
+	// The empty point is only of the summaries
+	if sm.shape.emptyBatch == emptyBatchEmit {
+		switch {
+		case sm.baseline != nil:
+			init.Error = "cannot 'emit' empty batches in 'baseline' mode"
+		case sm.emit == emitPoints:
+			init.Error = "cannot 'emit' empty batches with 'emit' points"
+		case sm.histogram != nil && sm.histogram.output == histogramPoints:
+			init.Error = "cannot 'emit' empty batches with 'histogram' points"
+		}
+		if init.Error != "" {
+			init.Success = false
+			return init, nil
 		}
 	}
 
-	if len(sm.field) == 0 {
+	if sm.timeZone, err = timezone.NewZone(timeZone, defaultTimeZone, unknownTimeZone); err != nil {
 		init.Success = false
-		init.Error = "must supply 'field'"
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	if sm.timeSource, err = timesource.NewSource(timeSource, timeSourceFormat, timeSourceError); err != nil {
+		init.Success = false
+		init.Error = err.Error()
 	}
 
 	return init, nil
 }
 
-// Create a snapshot of the running state of the process.
-func (*calcMeanStddev) Snapshot() (*agent.SnapshotResponse, error) {
-	return &agent.SnapshotResponse{}, nil
+// Create a snapshot of the running state of the process, which is the
+// exponentially weighted baselines if any.
+func (sm *calcMeanStddev) Snapshot() (*agent.SnapshotResponse, error) {
+	if sm.ewma == nil {
+		return &agent.SnapshotResponse{}, nil
+	}
+
+	data, err := json.Marshal(sm.ewma.groups)
+	if err != nil {
+		return nil, err
+	}
+
+	return &agent.SnapshotResponse{
+		Snapshot: data,
+	}, nil
 }
 
 // Restore a previous snapshot.
-func (*calcMeanStddev) Restore(req *agent.RestoreRequest) (*agent.RestoreResponse, error) {
+func (sm *calcMeanStddev) Restore(req *agent.RestoreRequest) (*agent.RestoreResponse, error) {
+	if sm.ewma == nil || len(req.Snapshot) == 0 {
+		return &agent.RestoreResponse{
+			Success: true,
+		}, nil
+	}
+
+	groups := make(map[string]*ewmaGroup)
+	if err := json.Unmarshal(req.Snapshot, &groups); err != nil {
+		return &agent.RestoreResponse{
+			Success: false,
+			Error:   "failed to restore snapshot: " + err.Error(),
+		}, nil
+	}
+
+	// A null group or field is dropped, like those never seen
+	for key, g := range groups {
+		if g == nil {
+			delete(groups, key)
+			continue
+		}
+		if g.Fields == nil {
+			g.Fields = make(map[string]*ewmaField)
+		}
+		for field, f := range g.Fields {
+			if f == nil {
+				delete(g.Fields, field)
+			}
+		}
+	}
+	sm.ewma.groups = groups
+
 	return &agent.RestoreResponse{
 		Success: true,
 	}, nil
@@ -87,46 +536,184 @@ func (*calcMeanStddev) Restore(req *agent.RestoreRequest) (*agent.RestoreRespons
 // Start working with the next batch
 func (sm *calcMeanStddev) BeginBatch(begin *agent.BeginBatch) error {
 	// Housekeeping for each time serise
-	sm.entries = nil
-	now := time.Now()
-	sm.now = converTimeToTimezone(&now, sm.timeZone)
-	sm.timeMask = sm.generateTimeMask()
+	sm.total = sm.newAccumulation()
+	sm.subgroups = make(map[string]*subgroup)
+	sm.points = nil
+	sm.last = nil
+	if sm.baseline != nil {
+		sm.baseline.reset()
+	}
+	sm.pending = nil
+	sm.latest = math.MinInt64
+
+	if !sm.isMaskDeferred() {
+		sm.now = sm.clock().In(sm.timeZone.Location())
+		sm.timeMask = sm.generateTimeMask()
+	}
 
 	return nil
 }
 
+// isMaskDeferred tells if the time mask is only known at the end of the
+// batch, as its "now" is a time of the batch.
+func (sm *calcMeanStddev) isMaskDeferred() bool {
+	return sm.reference != referenceWall && strings.Contains(sm.timeFilter, "now")
+}
+
 func (sm *calcMeanStddev) Point(p *agent.Point) error {
-	// Convert nanosecond epoch format time to timezone time
-	dt := time.Unix(0, p.GetTime())
-	dt = converTimeToTimezone(&dt, sm.timeZone)
+	if sm.emit != emitSummary {
+		sm.points = append(sm.points, p)
+	}
+	sm.last = p
 
-	// Only process data points that match time mask
-	if len(sm.timeMask) == 0 || matchtime.MatchTimeWithMask(sm.timeMask, &dt) {
-		val, ok := p.FieldsDouble[sm.field]
-		if !ok {
-			i := p.FieldsInt[sm.field]
-			val = float64(i)
+	// Read the event time of the point, which is the point time unless
+	// the 'timeSource' is given. A time source without a zone is in the
+	// point's time zone, so it cannot be read if that is unknown.
+	loc, zoneErr := sm.timeZone.Resolve(p)
+	t, err := sm.timeSource.Time(p, loc)
+	if err != nil {
+		if sm.timeSource.Policy() == timesource.PolicyFail {
+			return err
+		}
+		return nil
+	}
+
+	if t > sm.latest {
+		sm.latest = t
+	}
+
+	// Convert nanosecond epoch format time to timezone time. If the point's
+	// time zone is unknown, either drop it or use it without the time mask.
+	var dt time.Time
+	if zoneErr == nil {
+		dt = time.Unix(0, t).In(loc)
+	} else if sm.timeZone.Policy() == timezone.PolicyDrop {
+		return nil
+	}
+
+	values, missing := sm.fields.Read(p)
+	if err := sm.applyMissing(values, missing); err != nil {
+		return err
+	}
+
+	// The fields to correlate are read apart, as their statistics are
+	// not asked for
+	var pairValues map[string]float64
+	if sm.correlation != nil {
+		var pairMissing []string
+		pairValues, pairMissing = sm.correlation.fields.Read(p)
+		if err := sm.applyMissing(pairValues, pairMissing); err != nil {
+			return err
+		}
+	}
+
+	sub := sm.subgroup(p)
+	if sub != nil {
+		sub.last = p
+	}
+
+	if sm.isMaskDeferred() {
+		sm.pending = append(sm.pending, pendingPoint{t: t, dt: dt, unknownZone: zoneErr != nil, values: values, missing: missing, pairValues: pairValues, subgroup: sub})
+		return nil
+	}
+
+	sm.addValues(t, dt, zoneErr != nil, values, missing, pairValues, sub)
+
+	return nil
+}
+
+// applyMissing fails for missing fields, or reads them as 0, by the
+// 'missing' policy.
+func (sm *calcMeanStddev) applyMissing(values map[string]float64, missing []string) error {
+	if len(missing) == 0 {
+		return nil
+	}
+
+	switch sm.missing {
+	case missingFail:
+		return fmt.Errorf("missing or non-finite field '%s'", missing[0])
+	case missingZero:
+		for _, name := range missing {
+			values[name] = 0
 		}
-		sm.entries = append(sm.entries, val)
 	}
 
 	return nil
 }
 
-func converTimeToTimezone(t *time.Time, timeZone string) time.Time {
-	if len(timeZone) > 0 {
-		loc, err := time.LoadLocation(timeZone)
-		if err == nil {
-			return t.In(loc)
+func (sm *calcMeanStddev) addValues(t int64, dt time.Time, unknownZone bool, values map[string]float64, missing []string, pairValues map[string]float64, sub *subgroup) {
+	// Only process data points that match time mask, though the
+	// time weighting needs the others to bound the intervals
+	matched := unknownZone || len(sm.timeMask) == 0 || matchtime.MatchTimeWithMask(sm.timeMask, &dt)
+
+	if sm.baseline != nil {
+		sm.total.addQuality(values, missing, matched)
+		// Points of unknown time zone can't be put in a period
+		if matched && !unknownZone {
+			sm.baseline.add(dt, values)
 		}
+		return
+	}
+
+	sm.addToAccumulation(sm.total, t, values, missing, pairValues, matched)
+	if sub != nil {
+		sm.addToAccumulation(sub.acc, t, values, missing, pairValues, matched)
+	}
+}
+
+func (sm *calcMeanStddev) addToAccumulation(a *accumulation, t int64, values map[string]float64, missing []string, pairValues map[string]float64, matched bool) {
+	if a.weighting != nil {
+		a.weighting.add(t, values, matched)
+	}
+	a.addQuality(values, missing, matched)
+	if !matched {
+		return
+	}
+
+	if a.correlation != nil {
+		a.correlation.add(pairValues)
 	}
 
-	return *t
+	for name, val := range values {
+		s, ok := a.series[name]
+		if !ok {
+			s = &series{}
+			a.series[name] = s
+		}
+		s.add(val, sm.keepValues)
+		if sm.histogram != nil {
+			sm.histogram.add(s, val)
+		}
+		if sm.withTrend {
+			s.trend.add(t, val)
+		}
+	}
+}
+
+// applyDeferredMask generates the time mask from the time of the batch and
+// processes the points kept till the end of the batch.
+func (sm *calcMeanStddev) applyDeferredMask(end *agent.EndBatch) {
+	ref := end.GetTmax()
+	if sm.reference == referenceLatest && sm.latest != math.MinInt64 {
+		ref = sm.latest
+	}
+
+	sm.now = time.Unix(0, ref).In(sm.timeZone.Location())
+	sm.timeMask = sm.generateTimeMask()
+
+	for _, pp := range sm.pending {
+		sm.addValues(pp.t, pp.dt, pp.unknownZone, pp.values, pp.missing, pp.pairValues, pp.subgroup)
+	}
+	sm.pending = nil
 }
 
 func (sm *calcMeanStddev) generateTimeMask() string {
+	return substituteNow(sm.timeFilter, &sm.now)
+}
+
+func substituteNow(timeFilter string, now *time.Time) string {
 	// Replace the now field in the time filter with the related
-	// value of the time Now.
+	// value of the time now.
 	// For example, given the time now is 2019-08-27T20:30:00Z
 	// and the time filter is "W>=1 & W<=5 & h==now & m==now & Y==now",
 	// the result after processed is "W>=1 & W <=5 & h==20 & m==30 & Y==2019"
@@ -134,13 +721,13 @@ func (sm *calcMeanStddev) generateTimeMask() string {
 	var sb strings.Builder
 	curr := ""
 
-	for i := 0; i < len(sm.timeFilter); i++ {
-		ch := sm.timeFilter[i]
+	for i := 0; i < len(timeFilter); i++ {
+		ch := timeFilter[i]
 		if ch == 'h' || ch == 'm' || ch == 'W' || ch == 'D' || ch == 'M' || ch == 'Y' || ch == 's' {
 			curr = string(ch)
 			sb.WriteByte(ch)
 		} else if ch == 'n' {
-			v := matchtime.GetTimeField(curr, &sm.now)
+			v := matchtime.GetTimeField(curr, now)
 			sb.WriteString(strconv.Itoa(v))
 			i += 2
 		} else if !unicode.IsSpace(rune(ch)) {
@@ -152,21 +739,44 @@ func (sm *calcMeanStddev) generateTimeMask() string {
 }
 
 func (sm *calcMeanStddev) EndBatch(end *agent.EndBatch) error {
-	// Send the new data point back to Kapacitor
-	p := &agent.Point{
-		FieldsDouble: make(map[string]float64),
+	if sm.isMaskDeferred() {
+		sm.applyDeferredMask(end)
 	}
 
-	if len(sm.entries) > 0 {
-		m, sd := calculateMeanStddev(sm.entries)
+	// The baselines advance with every batch, whatever is sent for it
+	if sm.ewma != nil {
+		sm.updateEWMA(end)
+	}
 
-		p.FieldsDouble["mean"] = m
-		p.FieldsDouble["stddev"] = sd
-		p.Time = end.GetTmax()
-		p.Name = end.GetName()
-		p.Group = end.GetGroup()
-		p.Tags = end.GetTags()
+	if sm.emit != emitSummary {
+		sm.sendAnnotatedBatch(end)
+		if sm.emit == emitPoints {
+			return nil
+		}
+	}
+
+	if sm.histogram != nil && sm.histogram.output == histogramPoints {
+		sm.sendHistogramPoints(end)
+		return nil
+	}
 
+	if len(sm.by) > 0 {
+		sm.sendSubgroups(end)
+		return nil
+	}
+
+	// Send the new data point back to Kapacitor
+	p := sm.summaryPoint(end, sm.total, end.GetTags(), sm.last)
+	if p == nil && sm.shape.emptyBatch == emptyBatchEmit {
+		p = sm.emptyPoint(end, sm.total, end.GetTags(), sm.last)
+	}
+	switch {
+	case p == nil:
+	case sm.emit == emitBoth:
+		// The annotated batch is already ended, so the summary is framed
+		// by a batch of its own
+		sm.sendBatch(end, []*agent.Point{p})
+	default:
 		sm.agent.Responses <- &agent.Response{
 			Message: &agent.Response_Point{
 				Point: p,
@@ -177,26 +787,208 @@ func (sm *calcMeanStddev) EndBatch(end *agent.EndBatch) error {
 	return nil
 }
 
-// Stop the handler gracefully.
-func (sm *calcMeanStddev) Stop() {
-	close(sm.agent.Responses)
+// sendBatch sends the points framed by the begin and end of the batch.
+func (sm *calcMeanStddev) sendBatch(end *agent.EndBatch, points []*agent.Point) {
+	sm.agent.Responses <- &agent.Response{
+		Message: &agent.Response_Begin{
+			Begin: &agent.BeginBatch{
+				Name:   end.GetName(),
+				Group:  end.GetGroup(),
+				Tags:   end.GetTags(),
+				Size:   int64(len(points)),
+				ByName: end.GetByName(),
+			},
+		},
+	}
+
+	for _, p := range points {
+		sm.agent.Responses <- &agent.Response{
+			Message: &agent.Response_Point{
+				Point: p,
+			},
+		}
+	}
+
+	sm.agent.Responses <- &agent.Response{
+		Message: &agent.Response_End{
+			End: end,
+		},
+	}
+}
+
+// summaryPoint returns the point with the results of what's accumulated,
+// or nil if there are none. The tags and fields are copied from the last
+// point.
+func (sm *calcMeanStddev) summaryPoint(end *agent.EndBatch, a *accumulation, tags map[string]string, last *agent.Point) *agent.Point {
+	p := &agent.Point{
+		FieldsDouble: make(map[string]float64),
+		FieldsInt:    make(map[string]int64),
+		FieldsBool:   make(map[string]bool),
+	}
+
+	if sm.baseline != nil {
+		sm.addBaselineFields(p, end)
+	} else {
+		sm.addStatsFields(p, end, a)
+	}
+	if sm.histogram != nil {
+		sm.addHistogramFields(p, a)
+	}
+
+	if len(p.FieldsDouble)+len(p.FieldsInt)+len(p.FieldsBool) == 0 {
+		return nil
+	}
+
+	p.Time = end.GetTmax()
+	p.Name = end.GetName()
+	p.Group = end.GetGroup()
+	p.Tags = tags
+	sm.shape.apply(p, last)
+
+	return p
 }
 
-func calculateMeanStddev(data []float64) (float64, float64) {
-	var t float64
+func (sm *calcMeanStddev) addStatsFields(p *agent.Point, end *agent.EndBatch, a *accumulation) {
+	for _, field := range sortedKeys(a.series) {
+		if !a.hasMinCount(field, sm.minCount) {
+			continue
+		}
 
-	for _, v := range data {
-		t += v
+		s := a.series[field]
+		for stat, v := range calculateStats(&s.acc, s.values, sm.stats, sm.ddof) {
+			name := fieldstats.OutputFieldName(sm.fieldFormat, field, stat)
+			if stat == "count" {
+				p.FieldsInt[name] = int64(v)
+			} else {
+				p.FieldsDouble[name] = v
+			}
+		}
+		if sm.withTrend {
+			for stat, v := range s.trend.calculate(end.GetTmax(), sm.stats, sm.trendOpts) {
+				p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, stat)] = v
+			}
+		}
 	}
 
-	m := t / float64(len(data))
+	// Every field of the batch has counts, if only zeros
+	for field := range a.series {
+		a.fieldQuality(field)
+	}
+	for field, q := range a.quality {
+		if !a.hasMinCount(field, sm.minCount) {
+			continue
+		}
 
-	var va float64
-	for _, v := range data {
-		va += math.Pow(v-m, 2)
+		for _, stat := range sm.stats {
+			switch stat {
+			case "missing_count":
+				p.FieldsInt[fieldstats.OutputFieldName(sm.fieldFormat, field, stat)] = q.missing
+			case "masked_out_count":
+				p.FieldsInt[fieldstats.OutputFieldName(sm.fieldFormat, field, stat)] = q.maskedOut
+			}
+		}
 	}
 
-	return m, math.Sqrt(va / float64(len(data)))
+	if a.correlation != nil {
+		for _, r := range a.correlation.calculate(sm.ddof) {
+			p.FieldsInt[fieldstats.OutputFieldName(sm.fieldFormat, r.name, "pair_count")] = r.count
+			if r.hasCovariance {
+				p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, r.name, "covariance")] = r.covariance
+			}
+			if r.hasPearson {
+				p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, r.name, "pearson")] = r.pearson
+			}
+			if r.hasSpearman {
+				p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, r.name, "spearman")] = r.spearman
+			}
+		}
+	}
+
+	if sm.ewma != nil {
+		sm.addEWMAFields(p, a)
+	}
+
+	if a.weighting == nil {
+		return
+	}
+	for field, r := range a.weighting.calculate(end.Tmax) {
+		if !a.hasMinCount(field, sm.minCount) {
+			continue
+		}
+
+		for _, stat := range sm.stats {
+			switch stat {
+			case "twmean":
+				p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, stat)] = r.mean
+			case "twstddev":
+				p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, stat)] = r.stddev
+			}
+		}
+	}
+}
+
+// updateEWMA folds the batch means into the EWMA baselines of the group,
+// and of its sub-groups if any, keeping the results for the points.
+func (sm *calcMeanStddev) updateEWMA(end *agent.EndBatch) {
+	if len(sm.by) == 0 || sm.byTotal {
+		sm.updateAccumulationEWMA(end, sm.total, end.GetGroup())
+	}
+
+	// The EWMA baselines of a sub-group are apart from the group's
+	for key, sub := range sm.subgroups {
+		sm.updateAccumulationEWMA(end, sub.acc, end.GetGroup()+"\x00"+key)
+	}
+}
+
+func (sm *calcMeanStddev) updateAccumulationEWMA(end *agent.EndBatch, a *accumulation, key string) {
+	means := make(map[string]float64, len(a.series))
+	for field, s := range a.series {
+		if a.hasMinCount(field, sm.minCount) {
+			means[field] = s.acc.Mean()
+		}
+	}
+
+	a.ewma = sm.ewma.update(key, end.GetTmax(), means)
+}
+
+func (sm *calcMeanStddev) addEWMAFields(p *agent.Point, a *accumulation) {
+	for field, r := range a.ewma {
+		p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, "ewma_mean")] = r.mean
+		p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, "ewma_stddev")] = r.stddev
+		if r.hasDeviation {
+			p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, "ewma_deviation")] = r.deviation
+		}
+		if r.hasZScore {
+			p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, "ewma_zscore")] = r.zscore
+		}
+	}
+}
+
+func (sm *calcMeanStddev) addBaselineFields(p *agent.Point, end *agent.EndBatch) {
+	// The masks of the periods are relative to the end of the batch
+	tmax := time.Unix(0, end.GetTmax()).In(sm.timeZone.Location())
+
+	for field, r := range sm.baseline.calculate(tmax, sm.ddof) {
+		if r.hasCurrent {
+			p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, "current")] = r.current
+		}
+		if r.periods > 0 {
+			p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, "baseline_mean")] = r.mean
+			p.FieldsInt[fieldstats.OutputFieldName(sm.fieldFormat, field, "baseline_periods")] = r.periods
+		}
+		if r.hasStddev {
+			p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, "baseline_stddev")] = r.stddev
+		}
+		if r.hasZ {
+			p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, "zscore")] = r.zscore
+		}
+		p.FieldsBool[fieldstats.OutputFieldName(sm.fieldFormat, field, "anomaly")] = r.anomaly
+	}
+}
+
+// Stop the handler gracefully.
+func (sm *calcMeanStddev) Stop() {
+	close(sm.agent.Responses)
 }
 
 // Start is the entry point to start UDF
@@ -0,0 +1,190 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestEndBatchFields(t *testing.T) {
+	points := []*agent.Point{
+		{FieldsDouble: map[string]float64{"cpu": 1, "mem": 10}, FieldsInt: map[string]int64{"latency": 100}, FieldsString: map[string]string{"host": "a"}},
+		{FieldsDouble: map[string]float64{"cpu": 3, "mem": 30}, FieldsInt: map[string]int64{"latency": 300}, FieldsString: map[string]string{"host": "b"}},
+	}
+
+	for _, tc := range [...]struct {
+		opts     []*agent.Option
+		expected map[string]float64
+	}{
+		{
+			[]*agent.Option{stringOption("field", "cpu")},
+			map[string]float64{"mean": 2, "stddev": 1},
+		},
+		{
+			[]*agent.Option{stringOption("field", "cpu, latency")},
+			map[string]float64{"cpu_mean": 2, "cpu_stddev": 1, "latency_mean": 200, "latency_stddev": 100},
+		},
+		{
+			[]*agent.Option{stringOption("field", "cpu"), stringOption("field", "mem")},
+			map[string]float64{"cpu_mean": 2, "cpu_stddev": 1, "mem_mean": 20, "mem_stddev": 10},
+		},
+		{
+			[]*agent.Option{stringOption("field", "*"), stringOption("stats", "max")},
+			map[string]float64{"cpu_max": 3, "mem_max": 30, "latency_max": 300},
+		},
+		{
+			[]*agent.Option{stringOption("field", "cpu,mem"), stringOption("fieldFormat", "{stat}_of_{field}"), stringOption("stats", "sum")},
+			map[string]float64{"sum_of_cpu": 4, "sum_of_mem": 40},
+		},
+	} {
+		t.Run(fmt.Sprintf("Calculate stats of fields %v", tc.expected), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+			initHandler(t, sm, tc.opts...)
+
+			actual := runBatch(t, sm, points)
+			if len(actual.FieldsDouble) != len(tc.expected) {
+				t.Fatalf("expected %v, actual %v", tc.expected, actual.FieldsDouble)
+			}
+			for name, v := range tc.expected {
+				if math.Abs(actual.FieldsDouble[name]-v) > 1e-12 {
+					t.Errorf("%s: expected %v, actual %v", name, v, actual.FieldsDouble[name])
+				}
+			}
+		})
+	}
+}
+
+func TestInitInvalidFields(t *testing.T) {
+	for _, field := range []string{"", " , ", "cpu_[", "cpu,["} {
+		t.Run(fmt.Sprintf("Init with field '%s'", field), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{})
+			if init, _ := sm.Init(newInitRequest(stringOption("field", field))); init.Success {
+				t.Errorf("expected failure, actual success")
+			}
+		})
+	}
+}
+
+func initHandler(t *testing.T, sm *calcMeanStddev, opts ...*agent.Option) {
+	t.Helper()
+
+	init, _ := sm.Init(newInitRequest(opts...))
+	if !init.Success {
+		t.Fatalf("unexpected init error %v", init.Error)
+	}
+}
+
+// runBatch sends the points as a batch and returns the output point,
+// collected on a channel of its own.
+func runBatch(t *testing.T, sm *calcMeanStddev, points []*agent.Point) *agent.Point {
+	t.Helper()
+
+	ch := make(chan *agent.Response, 1)
+	sm.agent.Responses = ch
+
+	tmax := time.Date(2019, 8, 26, 3, 15, 0, 0, time.UTC).UnixNano()
+	sm.BeginBatch(&agent.BeginBatch{Name: "cpu"})
+	for _, p := range points {
+		if err := sm.Point(p); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	sm.EndBatch(&agent.EndBatch{Name: "cpu", Tmax: tmax})
+
+	if len(ch) == 0 {
+		return nil
+	}
+
+	return (<-ch).Message.(*agent.Response_Point).Point
+}
+
+func newInitRequest(opts ...*agent.Option) *agent.InitRequest {
+	r := &agent.InitRequest{}
+	for _, opt := range opts {
+		if opt != nil {
+			r.Options = append(r.Options, opt)
+		}
+	}
+
+	return r
+}
+
+func stringOption(name, value string) *agent.Option {
+	return &agent.Option{
+		Name:   name,
+		Values: []*agent.OptionValue{stringValue(value)},
+	}
+}
+
+func stringValue(s string) *agent.OptionValue {
+	return &agent.OptionValue{
+		Type:  agent.ValueType_STRING,
+		Value: &agent.OptionValue_StringValue{StringValue: s},
+	}
+}
+
+func intOption(name string, value int64) *agent.Option {
+	return &agent.Option{
+		Name: name,
+		Values: []*agent.OptionValue{
+			{Type: agent.ValueType_INT, Value: &agent.OptionValue_IntValue{IntValue: value}},
+		},
+	}
+}
+
+func durationOption(name string, value time.Duration) *agent.Option {
+	return &agent.Option{
+		Name: name,
+		Values: []*agent.OptionValue{
+			{Type: agent.ValueType_DURATION, Value: &agent.OptionValue_DurationValue{DurationValue: int64(value)}},
+		},
+	}
+}
+
+func TestEndBatchReference(t *testing.T) {
+	// The batch ends at 03:15 but its latest point is at 02:50, and
+	// the wall clock is at 05:00
+	tmax := time.Date(2019, 8, 26, 3, 15, 0, 0, time.UTC)
+	wall := time.Date(2019, 8, 26, 5, 0, 0, 0, time.UTC)
+	points := []*agent.Point{
+		{Time: tmax.Add(-2 * time.Hour).UnixNano(), FieldsDouble: map[string]float64{"cpu": 1}},
+		{Time: tmax.Add(-time.Hour).UnixNano(), FieldsDouble: map[string]float64{"cpu": 2}},
+		{Time: tmax.Add(-25 * time.Minute).UnixNano(), FieldsDouble: map[string]float64{"cpu": 3}},
+	}
+
+	for _, tc := range [...]struct {
+		reference string
+		expected  float64
+	}{
+		{"", 0},
+		{"wall", 0},
+		{"tmax", 1.5},
+		{"latest", 3},
+	} {
+		t.Run(fmt.Sprintf("Reference '%s'", tc.reference), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+			sm.clock = func() time.Time { return wall }
+			initHandler(t, sm,
+				stringOption("field", "cpu"),
+				stringOption("reference", tc.reference),
+				&agent.Option{
+					Name:   "timeFilter",
+					Values: []*agent.OptionValue{stringValue("m==now"), stringValue("")},
+				})
+
+			actual := runBatch(t, sm, points)
+			if tc.expected == 0 {
+				if actual != nil {
+					t.Errorf("expected no point, actual %v", actual.FieldsDouble)
+				}
+				return
+			}
+			if actual == nil || actual.FieldsDouble["mean"] != tc.expected {
+				t.Errorf("expected mean %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"pkg/fieldstats"
+)
+
+// correlation relates every pair of the fields by their covariance,
+// Pearson r and Spearman rho, over the points which have both fields of
+// the pair.
+type correlation struct {
+	fields *fieldstats.Selector
+	pairs  [][2]string
+
+	// The values of both fields by pair
+	samples [][]pairSample
+}
+
+type pairSample struct {
+	x float64
+	y float64
+}
+
+// pairResult is the relation of a pair of fields. The covariance is left
+// out if there are not more than ddof points with both fields, and the
+// correlations if either field is constant.
+type pairResult struct {
+	name  string
+	count int64
+
+	covariance    float64
+	hasCovariance bool
+
+	pearson     float64
+	spearman    float64
+	hasPearson  bool
+	hasSpearman bool
+}
+
+// newCorrelation returns nil if no fields are supplied.
+func newCorrelation(names []string) (*correlation, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate field '%s' to 'correlate'", name)
+		}
+		seen[name] = true
+	}
+	if len(names) < 2 {
+		return nil, fmt.Errorf("must supply at least two fields to 'correlate'")
+	}
+
+	c := &correlation{fields: fieldstats.NewNamesSelector(names)}
+	for i := range names {
+		for j := i + 1; j < len(names); j++ {
+			c.pairs = append(c.pairs, [2]string{names[i], names[j]})
+		}
+	}
+
+	return c, nil
+}
+
+// fork returns a correlation of the same pairs without samples, or nil
+// for nil.
+func (c *correlation) fork() *correlation {
+	if c == nil {
+		return nil
+	}
+
+	return &correlation{
+		fields:  c.fields,
+		pairs:   c.pairs,
+		samples: make([][]pairSample, len(c.pairs)),
+	}
+}
+
+func (c *correlation) add(values map[string]float64) {
+	for i, pair := range c.pairs {
+		x, ok := values[pair[0]]
+		if !ok {
+			continue
+		}
+		y, ok := values[pair[1]]
+		if !ok {
+			continue
+		}
+		c.samples[i] = append(c.samples[i], pairSample{x: x, y: y})
+	}
+}
+
+// calculate returns the results of the pairs in order, named like
+// "queue_latency" after the fields of the pair.
+func (c *correlation) calculate(ddof int64) []pairResult {
+	res := make([]pairResult, 0, len(c.pairs))
+
+	for i, pair := range c.pairs {
+		samples := c.samples[i]
+		r := pairResult{name: pair[0] + "_" + pair[1], count: int64(len(samples))}
+
+		xs := make([]float64, len(samples))
+		ys := make([]float64, len(samples))
+		for j, s := range samples {
+			xs[j], ys[j] = s.x, s.y
+		}
+
+		sxx, syy, sxy := comoments(xs, ys)
+		if r.count > ddof {
+			r.covariance = sxy / float64(r.count-ddof)
+			r.hasCovariance = true
+		}
+		if sxx > 0 && syy > 0 {
+			r.pearson = sxy / math.Sqrt(sxx*syy)
+			r.hasPearson = true
+		}
+
+		rx, ry := ranks(xs), ranks(ys)
+		if rxx, ryy, rxy := comoments(rx, ry); rxx > 0 && ryy > 0 {
+			r.spearman = rxy / math.Sqrt(rxx*ryy)
+			r.hasSpearman = true
+		}
+
+		res = append(res, r)
+	}
+
+	return res
+}
+
+// comoments returns the sums of the squared deviations from the means of
+// xs and ys, and of the products of their deviations.
+func comoments(xs []float64, ys []float64) (float64, float64, float64) {
+	var mx, my, sxx, syy, sxy float64
+	for i := range xs {
+		n := float64(i + 1)
+		dx := xs[i] - mx
+		dy := ys[i] - my
+		mx += dx / n
+		my += dy / n
+		sxx += dx * (xs[i] - mx)
+		syy += dy * (ys[i] - my)
+		sxy += dx * (ys[i] - my)
+	}
+
+	return sxx, syy, sxy
+}
+
+// ranks returns the ranks of the values from 1, the ties of which get
+// their average rank.
+func ranks(values []float64) []float64 {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return values[order[i]] < values[order[j]] })
+
+	res := make([]float64, len(values))
+	for i := 0; i < len(order); {
+		j := i
+		for j+1 < len(order) && values[order[j+1]] == values[order[i]] {
+			j++
+		}
+
+		rank := float64(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			res[order[k]] = rank
+		}
+		i = j + 1
+	}
+
+	return res
+}
@@ -0,0 +1,91 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestRanks(t *testing.T) {
+	for _, tc := range [...]struct {
+		values   []float64
+		expected []float64
+	}{
+		{[]float64{3, 1, 2}, []float64{3, 1, 2}},
+		{[]float64{2, 4, 5, 4, 5}, []float64{1, 2.5, 4.5, 2.5, 4.5}},
+		{[]float64{7, 7, 7}, []float64{2, 2, 2}},
+		{[]float64{}, []float64{}},
+	} {
+		t.Run(fmt.Sprintf("Ranks of %v", tc.values), func(t *testing.T) {
+			if actual := ranks(tc.values); !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestEndBatchCorrelate(t *testing.T) {
+	// The point at 02:45 is masked out, and the one missing the latency
+	// has no pair
+	tmax := time.Date(2019, 8, 26, 3, 15, 0, 0, time.UTC)
+	points := []*agent.Point{
+		{Time: tmax.Add(-time.Hour).UnixNano(), FieldsInt: map[string]int64{"queue": 1}, FieldsDouble: map[string]float64{"latency": 2}},
+		{Time: tmax.Add(-55 * time.Minute).UnixNano(), FieldsInt: map[string]int64{"queue": 2}, FieldsDouble: map[string]float64{"latency": 4}},
+		{Time: tmax.Add(-50 * time.Minute).UnixNano(), FieldsInt: map[string]int64{"queue": 100}},
+		{Time: tmax.Add(-30 * time.Minute).UnixNano(), FieldsInt: map[string]int64{"queue": 100}, FieldsDouble: map[string]float64{"latency": 0}},
+		{Time: tmax.Add(-25 * time.Minute).UnixNano(), FieldsInt: map[string]int64{"queue": 3}, FieldsDouble: map[string]float64{"latency": 5}},
+		{Time: tmax.Add(-20 * time.Minute).UnixNano(), FieldsInt: map[string]int64{"queue": 4}, FieldsDouble: map[string]float64{"latency": 4}},
+		{Time: tmax.Add(-15 * time.Minute).UnixNano(), FieldsInt: map[string]int64{"queue": 5}, FieldsDouble: map[string]float64{"latency": 5}},
+	}
+
+	sm := newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+	initHandler(t, sm,
+		stringOption("correlate", "queue,latency"),
+		&agent.Option{
+			Name:   "timeFilter",
+			Values: []*agent.OptionValue{stringValue("m!=45"), stringValue("UTC")},
+		})
+
+	actual := runBatch(t, sm, points)
+	if actual == nil {
+		t.Fatalf("expected a point")
+	}
+	if actual.FieldsInt["pair_count"] != 5 {
+		t.Errorf("expected 5 pairs, actual %v", actual.FieldsInt)
+	}
+	for name, want := range map[string]float64{
+		"covariance": 1.2,
+		"pearson":    6 / math.Sqrt(60),
+		"spearman":   7 / math.Sqrt(90),
+	} {
+		if math.Abs(actual.FieldsDouble[name]-want) > 1e-12 {
+			t.Errorf("expected %s %v, actual %v", name, want, actual.FieldsDouble[name])
+		}
+	}
+}
+
+func TestNewCorrelation(t *testing.T) {
+	for _, tc := range [...]struct {
+		names    []string
+		expected [][2]string
+		isErr    bool
+	}{
+		{[]string{"a", "b", "c"}, [][2]string{{"a", "b"}, {"a", "c"}, {"b", "c"}}, false},
+		{[]string{"a"}, nil, true},
+		{[]string{"a", "a"}, nil, true},
+	} {
+		t.Run(fmt.Sprintf("Correlate %v", tc.names), func(t *testing.T) {
+			c, err := newCorrelation(tc.names)
+			if (err != nil) != tc.isErr {
+				t.Fatalf("expected error %v, actual %v", tc.isErr, err)
+			}
+			if !tc.isErr && !reflect.DeepEqual(tc.expected, c.pairs) {
+				t.Errorf("expected %v, actual %v", tc.expected, c.pairs)
+			}
+		})
+	}
+}
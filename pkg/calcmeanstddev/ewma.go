@@ -0,0 +1,118 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+)
+
+// ewma keeps the exponentially weighted moving mean and variance of the
+// batch means per group, carried from batch to batch. The weight of the
+// current batch is either the fixed alpha, or derived from the half-life
+// and the time since the previous batch, so that irregular batches decay
+// by time rather than by count.
+type ewma struct {
+	alpha    float64
+	halfLife int64
+
+	groups map[string]*ewmaGroup
+}
+
+type ewmaGroup struct {
+	Tmax   int64                 `json:"tmax"`
+	Fields map[string]*ewmaField `json:"fields"`
+}
+
+type ewmaField struct {
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+}
+
+// ewmaResult is the deviation of the batch mean of a field from the
+// smoothed values before the batch, and the smoothed values after it.
+type ewmaResult struct {
+	mean         float64
+	stddev       float64
+	hasDeviation bool
+	deviation    float64
+	zscore       float64
+	hasZScore    bool
+}
+
+// newEWMA returns nil if neither alpha nor the half-life is supplied.
+func newEWMA(alpha float64, halfLife int64) (*ewma, error) {
+	if alpha == 0 && halfLife == 0 {
+		return nil, nil
+	}
+	if alpha != 0 && halfLife != 0 {
+		return nil, fmt.Errorf("cannot supply both 'alpha' and 'halfLife'")
+	}
+	if alpha < 0 || alpha > 1 {
+		return nil, fmt.Errorf("'alpha' must be above 0 and at most 1")
+	}
+	if halfLife < 0 {
+		return nil, fmt.Errorf("'halfLife' must be positive")
+	}
+
+	return &ewma{
+		alpha:    alpha,
+		halfLife: halfLife,
+		groups:   make(map[string]*ewmaGroup),
+	}, nil
+}
+
+// weight returns the weight of a batch ending at tmax, after the previous
+// batch of the group ending at prev.
+func (e *ewma) weight(prev int64, tmax int64) float64 {
+	if e.halfLife == 0 {
+		return e.alpha
+	}
+	if tmax <= prev {
+		return 0
+	}
+
+	return 1 - math.Exp2(-float64(tmax-prev)/float64(e.halfLife))
+}
+
+// update folds the batch means of the fields into the smoothed values of
+// the group, and returns the results for the fields of the batch. The
+// first batch of a field only starts the smoothed values.
+func (e *ewma) update(group string, tmax int64, means map[string]float64) map[string]ewmaResult {
+	g, ok := e.groups[group]
+	if !ok {
+		g = &ewmaGroup{Tmax: tmax, Fields: make(map[string]*ewmaField)}
+		e.groups[group] = g
+	}
+	alpha := e.weight(g.Tmax, tmax)
+
+	res := make(map[string]ewmaResult, len(means))
+	for field, x := range means {
+		f, ok := g.Fields[field]
+		if !ok {
+			g.Fields[field] = &ewmaField{Mean: x}
+			res[field] = ewmaResult{mean: x}
+			continue
+		}
+
+		r := ewmaResult{hasDeviation: true, deviation: x - f.Mean}
+		if sd := math.Sqrt(f.Variance); sd > 0 {
+			r.zscore = r.deviation / sd
+			r.hasZScore = true
+		}
+
+		// The incremental form of West (1979), which keeps the variance
+		// from going negative
+		incr := alpha * r.deviation
+		f.Mean += incr
+		f.Variance = (1 - alpha) * (f.Variance + r.deviation*incr)
+
+		r.mean = f.Mean
+		r.stddev = math.Sqrt(f.Variance)
+		res[field] = r
+	}
+
+	if tmax > g.Tmax {
+		g.Tmax = tmax
+	}
+
+	return res
+}
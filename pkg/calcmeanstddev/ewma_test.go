@@ -0,0 +1,156 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestEndBatchEWMA(t *testing.T) {
+	alpha := &agent.Option{
+		Name: "alpha",
+		Values: []*agent.OptionValue{
+			{Type: agent.ValueType_DOUBLE, Value: &agent.OptionValue_DoubleValue{DoubleValue: 0.5}},
+		},
+	}
+	sm := newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+	initHandler(t, sm, stringOption("field", "cpu"), alpha)
+
+	for i, tc := range [...]struct {
+		mean     float64
+		expected map[string]float64
+		restore  bool
+	}{
+		{10, map[string]float64{"ewma_mean": 10, "ewma_stddev": 0}, false},
+		{20, map[string]float64{"ewma_mean": 15, "ewma_stddev": 5, "ewma_deviation": 10}, false},
+		{20, map[string]float64{"ewma_mean": 17.5, "ewma_stddev": math.Sqrt(18.75), "ewma_deviation": 5, "ewma_zscore": 1}, false},
+		{20, map[string]float64{"ewma_mean": 18.75, "ewma_stddev": math.Sqrt(0.5 * (18.75 + 2.5*1.25)),
+			"ewma_deviation": 2.5, "ewma_zscore": 2.5 / math.Sqrt(18.75)}, true},
+	} {
+		t.Run(fmt.Sprintf("Batch %d with mean %v", i, tc.mean), func(t *testing.T) {
+			if tc.restore {
+				snapshot, err := sm.Snapshot()
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				sm = newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+				initHandler(t, sm, stringOption("field", "cpu"), alpha)
+				if r, _ := sm.Restore(&agent.RestoreRequest{Snapshot: snapshot.Snapshot}); !r.Success {
+					t.Fatalf("unexpected restore failure %v", r.Error)
+				}
+			}
+
+			actual := runBatch(t, sm, []*agent.Point{
+				{FieldsDouble: map[string]float64{"cpu": tc.mean - 1}},
+				{FieldsDouble: map[string]float64{"cpu": tc.mean + 1}},
+			})
+			for name, want := range tc.expected {
+				if v, ok := actual.FieldsDouble[name]; !ok || math.Abs(v-want) > 1e-12 {
+					t.Errorf("expected %s %v, actual %v", name, want, actual.FieldsDouble)
+				}
+			}
+			for _, name := range []string{"ewma_deviation", "ewma_zscore"} {
+				if _, ok := tc.expected[name]; !ok {
+					if _, ok := actual.FieldsDouble[name]; ok {
+						t.Errorf("expected no %s, actual %v", name, actual.FieldsDouble)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEWMAEmitPoints(t *testing.T) {
+	alpha := &agent.Option{
+		Name: "alpha",
+		Values: []*agent.OptionValue{
+			{Type: agent.ValueType_DOUBLE, Value: &agent.OptionValue_DoubleValue{DoubleValue: 0.5}},
+		},
+	}
+	sm := newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 16)})
+	initHandler(t, sm, stringOption("field", "cpu"), stringOption("emit", "points"), alpha)
+
+	// The baselines advance though no summary is sent
+	for _, mean := range []float64{10, 20} {
+		sm.BeginBatch(&agent.BeginBatch{Name: "cpu"})
+		sm.Point(&agent.Point{FieldsDouble: map[string]float64{"cpu": mean - 1}})
+		sm.Point(&agent.Point{FieldsDouble: map[string]float64{"cpu": mean + 1}})
+		sm.EndBatch(&agent.EndBatch{Name: "cpu", Group: "host=a"})
+	}
+
+	g, ok := sm.ewma.groups["host=a"]
+	if !ok {
+		t.Fatalf("expected the baselines of the group, actual %v", sm.ewma.groups)
+	}
+	if f := g.Fields["cpu"]; f == nil || f.Mean != 15 || f.Variance != 25 {
+		t.Errorf("expected mean 15 and variance 25, actual %+v", f)
+	}
+}
+
+func TestEWMARestoreNull(t *testing.T) {
+	alpha := &agent.Option{
+		Name: "alpha",
+		Values: []*agent.OptionValue{
+			{Type: agent.ValueType_DOUBLE, Value: &agent.OptionValue_DoubleValue{DoubleValue: 0.5}},
+		},
+	}
+
+	for _, snapshot := range []string{
+		`{"":null}`,
+		`{"":{"tmax":0,"fields":null}}`,
+		`{"":{"tmax":0,"fields":{"cpu":null}}}`,
+	} {
+		t.Run(fmt.Sprintf("Restore %s", snapshot), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{})
+			initHandler(t, sm, stringOption("field", "cpu"), alpha)
+			if r, _ := sm.Restore(&agent.RestoreRequest{Snapshot: []byte(snapshot)}); !r.Success {
+				t.Fatalf("unexpected restore failure %v", r.Error)
+			}
+
+			actual := runBatch(t, sm, []*agent.Point{{FieldsDouble: map[string]float64{"cpu": 10}}})
+			if actual == nil || actual.FieldsDouble["ewma_mean"] != 10 {
+				t.Errorf("expected the baseline to start over, actual %v", actual)
+			}
+		})
+	}
+}
+
+func TestEWMAWeight(t *testing.T) {
+	e, _ := newEWMA(0, int64(time.Hour))
+	for _, tc := range [...]struct {
+		elapsed  time.Duration
+		expected float64
+	}{
+		{time.Hour, 0.5},
+		{2 * time.Hour, 0.75},
+		{0, 0},
+		{-time.Hour, 0},
+	} {
+		t.Run(fmt.Sprintf("Weight after %v", tc.elapsed), func(t *testing.T) {
+			if actual := e.weight(0, int64(tc.elapsed)); math.Abs(actual-tc.expected) > 1e-12 {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestInitInvalidEWMA(t *testing.T) {
+	for _, tc := range [...]struct {
+		alpha    float64
+		halfLife time.Duration
+	}{
+		{1.5, 0},
+		{-0.5, 0},
+		{0.5, time.Hour},
+		{0, -time.Hour},
+	} {
+		t.Run(fmt.Sprintf("Alpha %v half-life %v", tc.alpha, tc.halfLife), func(t *testing.T) {
+			if _, err := newEWMA(tc.alpha, int64(tc.halfLife)); err == nil {
+				t.Errorf("expected error, actual none")
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package calcmeanstddev
+
+import (
+	"sort"
+
+	"pkg/fieldstats"
+)
+
+// series holds what's accumulated for a field in a batch: the moments,
+// the values only if needed for medians or percentiles, the trend against
+// time and the counts of the histogram buckets only if asked for.
+type series struct {
+	acc     fieldstats.Accumulator
+	values  []float64
+	trend   trend
+	buckets []int64
+}
+
+func (s *series) add(v float64, keepValue bool) {
+	s.acc.Add(v)
+	if keepValue {
+		s.values = append(s.values, v)
+	}
+}
+
+// sortedKeys returns the names of the series in order, so that the
+// output is stable.
+func sortedKeys(m map[string]*series) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
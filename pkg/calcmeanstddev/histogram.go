@@ -0,0 +1,190 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+
+	"pkg/fieldstats"
+)
+
+// Where the bucket counts of the histogram go: fields like "le_100" of the
+// summary point, or one point per bucket tagged with its bound "le".
+const (
+	histogramFields = "fields"
+	histogramPoints = "points"
+)
+
+// histogram counts the values of the batch in buckets by upper bounds,
+// cumulatively like the buckets of Prometheus, i.e. the count of a bucket
+// is of the values less than or equal to its bound. The last bucket is
+// unbounded and counts all values.
+type histogram struct {
+	bounds []float64
+	output string
+}
+
+// newHistogram parses the buckets by their kind, which could be
+//   - "fixed" with "start,width,count" for count buckets of the width,
+//     the first of which is bounded by start
+//   - "log" with "start,factor,count" for count buckets growing by the
+//     factor, the first of which is bounded by start
+//   - "explicit" with the increasing bounds like "100,250,500"
+//
+// It returns nil if no kind is supplied.
+func newHistogram(kind string, spec string, output string) (*histogram, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind == "" {
+		return nil, nil
+	}
+
+	switch output = strings.ToLower(strings.TrimSpace(output)); output {
+	case "":
+		output = histogramFields
+	case histogramFields, histogramPoints:
+	default:
+		return nil, fmt.Errorf("invalid 'histogramOutput' '%s', must be 'fields' or 'points'", output)
+	}
+
+	var params []float64
+	for _, s := range strings.Split(spec, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid 'histogram' bound '%s'", strings.TrimSpace(s))
+		}
+		params = append(params, v)
+	}
+
+	h := &histogram{output: output}
+
+	switch kind {
+	case "fixed", "log":
+		if len(params) != 3 || params[2] < 1 || params[2] != math.Trunc(params[2]) {
+			return nil, fmt.Errorf("'%s' histogram needs a start, a step and a positive whole count", kind)
+		}
+		start, step, count := params[0], params[1], int(params[2])
+		if kind == "fixed" && step <= 0 {
+			return nil, fmt.Errorf("width of 'fixed' histogram must be positive")
+		}
+		if kind == "log" && (start <= 0 || step <= 1) {
+			return nil, fmt.Errorf("'log' histogram needs a positive start and a factor above 1")
+		}
+
+		for i := 0; i < count; i++ {
+			if kind == "fixed" {
+				h.bounds = append(h.bounds, start+float64(i)*step)
+			} else {
+				h.bounds = append(h.bounds, start*math.Pow(step, float64(i)))
+			}
+		}
+	case "explicit":
+		for i, b := range params {
+			if i > 0 && b <= params[i-1] {
+				return nil, fmt.Errorf("bounds of 'explicit' histogram must be increasing")
+			}
+		}
+		h.bounds = params
+	default:
+		return nil, fmt.Errorf("invalid 'histogram' '%s', must be 'fixed', 'log' or 'explicit'", kind)
+	}
+
+	return h, nil
+}
+
+// add counts the value v in its bucket of the series, the first whose
+// bound is not less than v.
+func (h *histogram) add(s *series, v float64) {
+	if s.buckets == nil {
+		s.buckets = make([]int64, len(h.bounds)+1)
+	}
+	s.buckets[sort.SearchFloat64s(h.bounds, v)]++
+}
+
+// counts returns the cumulative count of each bucket of the series.
+func (h *histogram) counts(s *series) []int64 {
+	counts := make([]int64, len(h.bounds)+1)
+	var total int64
+	for i := range counts {
+		if i < len(s.buckets) {
+			total += s.buckets[i]
+		}
+		counts[i] = total
+	}
+
+	return counts
+}
+
+// bound formats the bound of the i-th bucket, "+Inf" for the last one.
+func (h *histogram) bound(i int) string {
+	if i == len(h.bounds) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(h.bounds[i], 'f', -1, 64)
+}
+
+// bucketStat names the count of the i-th bucket as a statistic, like
+// "le_100" or "le_inf".
+func (h *histogram) bucketStat(i int) string {
+	if i == len(h.bounds) {
+		return "le_inf"
+	}
+
+	return "le_" + h.bound(i)
+}
+
+func (sm *calcMeanStddev) addHistogramFields(p *agent.Point, a *accumulation) {
+	for _, field := range sortedKeys(a.series) {
+		if !a.hasMinCount(field, sm.minCount) {
+			continue
+		}
+
+		for i, c := range sm.histogram.counts(a.series[field]) {
+			p.FieldsInt[fieldstats.OutputFieldName(sm.fieldFormat, field, sm.histogram.bucketStat(i))] = c
+		}
+	}
+}
+
+// sendHistogramPoints sends one point per bucket with the counts of the
+// fields, tagged with the bound "le" of the bucket, framed by the begin
+// and end of the batch.
+func (sm *calcMeanStddev) sendHistogramPoints(end *agent.EndBatch) {
+	var fields []string
+	for _, field := range sortedKeys(sm.total.series) {
+		if sm.total.hasMinCount(field, sm.minCount) {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	points := make([]*agent.Point, len(sm.histogram.bounds)+1)
+	for i := range points {
+		tags := make(map[string]string, len(end.GetTags())+1)
+		for k, v := range end.GetTags() {
+			tags[k] = v
+		}
+		tags["le"] = sm.histogram.bound(i)
+
+		points[i] = &agent.Point{
+			Time:      end.GetTmax(),
+			Name:      end.GetName(),
+			Group:     end.GetGroup(),
+			Tags:      tags,
+			FieldsInt: make(map[string]int64, len(fields)),
+		}
+	}
+
+	for _, field := range fields {
+		for i, c := range sm.histogram.counts(sm.total.series[field]) {
+			points[i].FieldsInt[fieldstats.OutputFieldName(sm.fieldFormat, field, "count")] = c
+		}
+	}
+
+	sm.sendBatch(end, points)
+}
@@ -0,0 +1,100 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestNewHistogram(t *testing.T) {
+	for _, tc := range [...]struct {
+		kind     string
+		spec     string
+		expected []float64
+		isErr    bool
+	}{
+		{"fixed", "0,100,3", []float64{0, 100, 200}, false},
+		{"log", "1,10,4", []float64{1, 10, 100, 1000}, false},
+		{"Explicit", "100, 250,500", []float64{100, 250, 500}, false},
+		{"explicit", "100,100", nil, true},
+		{"fixed", "0,0,3", nil, true},
+		{"fixed", "0,10", nil, true},
+		{"fixed", "0,10,2.5", nil, true},
+		{"log", "0,10,3", nil, true},
+		{"log", "1,1,3", nil, true},
+		{"explicit", "100,x", nil, true},
+		{"normal", "1", nil, true},
+	} {
+		t.Run(fmt.Sprintf("New '%s' histogram '%s'", tc.kind, tc.spec), func(t *testing.T) {
+			h, err := newHistogram(tc.kind, tc.spec, "")
+			if (err != nil) != tc.isErr {
+				t.Fatalf("expected error %v, actual %v", tc.isErr, err)
+			}
+			if !tc.isErr && !reflect.DeepEqual(tc.expected, h.bounds) {
+				t.Errorf("expected %v, actual %v", tc.expected, h.bounds)
+			}
+		})
+	}
+}
+
+func TestEndBatchHistogram(t *testing.T) {
+	values := []float64{50, 100, 120, 300, 240, 900}
+
+	for _, tc := range [...]struct {
+		output   string
+		expected map[string]int64
+	}{
+		{"", map[string]int64{"le_100": 2, "le_250": 4, "le_500": 5, "le_inf": 6}},
+		{"points", map[string]int64{"100": 2, "250": 4, "500": 5, "+Inf": 6}},
+	} {
+		t.Run(fmt.Sprintf("Histogram output '%s'", tc.output), func(t *testing.T) {
+			ch := make(chan *agent.Response, 8)
+			sm := newCalcMeanStddev(&agent.Agent{Responses: ch})
+			initHandler(t, sm,
+				stringOption("field", "latency"),
+				stringOption("stats", "count"),
+				stringOption("histogramOutput", tc.output),
+				&agent.Option{
+					Name:   "histogram",
+					Values: []*agent.OptionValue{stringValue("explicit"), stringValue("100,250,500")},
+				})
+			// The buckets are counted as the values come, not from the values
+			if sm.keepValues {
+				t.Errorf("expected the values not to be kept for the histogram")
+			}
+
+			sm.BeginBatch(&agent.BeginBatch{Name: "http"})
+			for _, v := range values {
+				sm.Point(&agent.Point{FieldsDouble: map[string]float64{"latency": v}})
+			}
+			sm.EndBatch(&agent.EndBatch{Name: "http", Tags: map[string]string{"host": "a"}})
+
+			if tc.output == "" {
+				p := (<-ch).Message.(*agent.Response_Point).Point
+				tc.expected["count"] = 6
+				if !reflect.DeepEqual(tc.expected, p.FieldsInt) {
+					t.Errorf("expected %v, actual %v", tc.expected, p.FieldsInt)
+				}
+				return
+			}
+
+			if len(ch) != 6 {
+				t.Fatalf("expected 6 responses, actual %d", len(ch))
+			}
+			<-ch
+			actual := make(map[string]int64)
+			for i := 0; i < 4; i++ {
+				p := (<-ch).Message.(*agent.Response_Point).Point
+				if p.Tags["host"] != "a" {
+					t.Errorf("expected tags of the batch, actual %v", p.Tags)
+				}
+				actual[p.Tags["le"]] = p.FieldsInt["count"]
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"strings"
+)
+
+// What to do with a field which is missing from a point, or not a finite
+// number: leave it out, count it as 0, or fail the task.
+const (
+	missingSkip = "skip"
+	missingZero = "zero"
+	missingFail = "fail"
+)
+
+func parseMissing(missing string) (string, error) {
+	switch missing = strings.ToLower(strings.TrimSpace(missing)); missing {
+	case "":
+		return missingSkip, nil
+	case missingSkip, missingZero, missingFail:
+		return missing, nil
+	}
+
+	return "", fmt.Errorf("invalid 'missing' value '%s', must be 'skip', 'zero' or 'fail'", missing)
+}
+
+// fieldQuality counts the points of a field in the batch which are not
+// in its statistics, to judge the quality of the data by.
+type fieldQuality struct {
+	missing   int64
+	maskedOut int64
+}
+
+// isQualityStat tells if the statistic is one of the data quality
+// counts, which are kept apart from the accumulated values.
+func isQualityStat(name string) bool {
+	return name == "missing_count" || name == "masked_out_count"
+}
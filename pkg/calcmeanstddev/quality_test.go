@@ -0,0 +1,86 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestEndBatchMissing(t *testing.T) {
+	// The batch ends at 03:15, and the point at 02:45 is masked out
+	tmax := time.Date(2019, 8, 26, 3, 15, 0, 0, time.UTC)
+	points := []*agent.Point{
+		{Time: tmax.Add(-time.Hour).UnixNano(), FieldsDouble: map[string]float64{"cpu": 4}},
+		{Time: tmax.Add(-50 * time.Minute).UnixNano(), FieldsDouble: map[string]float64{"cpu": math.NaN()}},
+		{Time: tmax.Add(-40 * time.Minute).UnixNano(), FieldsInt: map[string]int64{"mem": 1}},
+		{Time: tmax.Add(-30 * time.Minute).UnixNano(), FieldsDouble: map[string]float64{"cpu": 100}},
+		{Time: tmax.Add(-20 * time.Minute).UnixNano(), FieldsDouble: map[string]float64{"cpu": math.Inf(1)}},
+		{Time: tmax.Add(-10 * time.Minute).UnixNano(), FieldsInt: map[string]int64{"cpu": 8}},
+	}
+
+	for _, tc := range [...]struct {
+		missing  string
+		minCount int64
+		ints     map[string]int64
+		doubles  map[string]float64
+	}{
+		{"", 0, map[string]int64{"count": 2, "missing_count": 3, "masked_out_count": 1}, map[string]float64{"mean": 6}},
+		{"skip", 2, map[string]int64{"count": 2, "missing_count": 3, "masked_out_count": 1}, map[string]float64{"mean": 6}},
+		{"zero", 0, map[string]int64{"count": 5, "missing_count": 3, "masked_out_count": 1}, map[string]float64{"mean": 2.4}},
+		{"skip", 3, nil, nil},
+	} {
+		t.Run(fmt.Sprintf("Missing '%s' min count %d", tc.missing, tc.minCount), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+			initHandler(t, sm,
+				stringOption("field", "cpu"),
+				stringOption("stats", "mean,count,missing_count,masked_out_count"),
+				stringOption("missing", tc.missing),
+				&agent.Option{
+					Name:   "minCount",
+					Values: []*agent.OptionValue{{Type: agent.ValueType_INT, Value: &agent.OptionValue_IntValue{IntValue: tc.minCount}}},
+				},
+				&agent.Option{
+					Name:   "timeFilter",
+					Values: []*agent.OptionValue{stringValue("m!=45"), stringValue("UTC")},
+				})
+
+			actual := runBatch(t, sm, points)
+			if tc.ints == nil {
+				if actual != nil {
+					t.Errorf("expected no point, actual %v %v", actual.FieldsInt, actual.FieldsDouble)
+				}
+				return
+			}
+			if actual == nil {
+				t.Fatalf("expected a point")
+			}
+			if !reflect.DeepEqual(tc.ints, actual.FieldsInt) || !reflect.DeepEqual(tc.doubles, actual.FieldsDouble) {
+				t.Errorf("expected %v %v, actual %v %v", tc.ints, tc.doubles, actual.FieldsInt, actual.FieldsDouble)
+			}
+		})
+	}
+}
+
+func TestPointMissingFail(t *testing.T) {
+	sm := newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+	initHandler(t, sm, stringOption("field", "cpu"), stringOption("missing", "fail"))
+
+	sm.BeginBatch(&agent.BeginBatch{Name: "cpu"})
+	if err := sm.Point(&agent.Point{FieldsDouble: map[string]float64{"cpu": 1}}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := sm.Point(&agent.Point{FieldsString: map[string]string{"cpu": "high"}}); err == nil {
+		t.Errorf("expected error for missing field")
+	}
+}
+
+func TestInitInvalidMissing(t *testing.T) {
+	sm := newCalcMeanStddev(&agent.Agent{})
+	if init, _ := sm.Init(newInitRequest(stringOption("field", "cpu"), stringOption("missing", "mean"))); init.Success {
+		t.Errorf("expected failure, actual success")
+	}
+}
@@ -0,0 +1,102 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pkg/fieldstats"
+)
+
+// madScale scales the median absolute deviation to a consistent
+// estimator of the stddev of normally distributed data.
+const madScale = 1.4826
+
+// defaultCut is the percentage cut off each end of the data by "trimmean",
+// "winsormean" and "winsorstddev" if not given after the name.
+const defaultCut = 10.0
+
+// The robust statistics, which are not wrecked by a few spikes. The
+// trimmed and winsorized statistics take the percentage cut off each end
+// of the data after the name, like "trimmean20".
+var robustStats = []string{"trimmean", "winsormean", "winsorstddev", "mad", "smad", "iqr"}
+
+// parseRobust splits the name of a robust statistic into its kind and
+// the percentage cut off each end. It returns an empty kind if the name
+// is not a robust statistic.
+func parseRobust(name string) (string, float64, error) {
+	for _, kind := range robustStats {
+		if !strings.HasPrefix(name, kind) {
+			continue
+		}
+
+		suffix := name[len(kind):]
+		if suffix == "" {
+			return kind, defaultCut, nil
+		}
+		if kind != "trimmean" && kind != "winsormean" && kind != "winsorstddev" {
+			break
+		}
+
+		cut, err := strconv.ParseFloat(suffix, 64)
+		if err != nil || cut < 0 || cut >= 50 {
+			return "", 0, fmt.Errorf("invalid statistic '%s', the percentage must be at least 0 and below 50", name)
+		}
+		return kind, cut, nil
+	}
+
+	return "", 0, nil
+}
+
+// robustStatistic calculates the robust statistic of the sorted data.
+// The winsorized stddev is not ok if there are not more than ddof values.
+func robustStatistic(name string, sorted []float64, ddof int64) (float64, bool) {
+	kind, cut, _ := parseRobust(name)
+	n := len(sorted)
+	k := int(math.Floor(float64(n) * cut / 100))
+
+	switch kind {
+	case "trimmean":
+		var acc fieldstats.Accumulator
+		for _, v := range sorted[k : n-k] {
+			acc.Add(v)
+		}
+		return acc.Mean(), true
+	case "winsormean", "winsorstddev":
+		var acc fieldstats.Accumulator
+		for i, v := range sorted {
+			if i < k {
+				v = sorted[k]
+			} else if i >= n-k {
+				v = sorted[n-k-1]
+			}
+			acc.Add(v)
+		}
+		if kind == "winsormean" {
+			return acc.Mean(), true
+		}
+		return acc.Stddev(ddof)
+	case "mad":
+		return medianAbsoluteDeviation(sorted), true
+	case "smad":
+		return madScale * medianAbsoluteDeviation(sorted), true
+	case "iqr":
+		return percentile(sorted, 75) - percentile(sorted, 25), true
+	}
+
+	return 0, false
+}
+
+func medianAbsoluteDeviation(sorted []float64) float64 {
+	median := percentile(sorted, 50)
+
+	deviations := make([]float64, len(sorted))
+	for i, v := range sorted {
+		deviations[i] = math.Abs(v - median)
+	}
+	sort.Float64s(deviations)
+
+	return percentile(deviations, 50)
+}
@@ -0,0 +1,108 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+
+	"pkg/fieldstats"
+)
+
+func TestParseRobust(t *testing.T) {
+	for _, tc := range [...]struct {
+		name  string
+		kind  string
+		cut   float64
+		isErr bool
+	}{
+		{"trimmean", "trimmean", 10, false},
+		{"trimmean20", "trimmean", 20, false},
+		{"winsorstddev5.5", "winsorstddev", 5.5, false},
+		{"smad", "smad", 10, false},
+		{"iqr", "iqr", 10, false},
+		{"trimmean50", "", 0, true},
+		{"winsormeanx", "", 0, true},
+		{"mad5", "", 0, false},
+		{"mean", "", 0, false},
+	} {
+		t.Run(fmt.Sprintf("Parse robust statistic '%s'", tc.name), func(t *testing.T) {
+			kind, cut, err := parseRobust(tc.name)
+			if (err != nil) != tc.isErr {
+				t.Fatalf("expected error %v, actual %v", tc.isErr, err)
+			}
+			if kind != tc.kind || (kind != "" && cut != tc.cut) {
+				t.Errorf("expected %s %v, actual %s %v", tc.kind, tc.cut, kind, cut)
+			}
+		})
+	}
+}
+
+func TestCalculateRobustStats(t *testing.T) {
+	data := []float64{100, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	expected := map[string]float64{
+		"trimmean":     5.5,
+		"trimmean20":   5.5,
+		"trimmean0":    14.5,
+		"winsormean":   5.5,
+		"winsorstddev": math.Sqrt(6.65),
+		"mad":          2.5,
+		"smad":         2.5 * madScale,
+		"iqr":          4.5,
+	}
+
+	stats := make([]string, 0, len(expected))
+	for name := range expected {
+		stats = append(stats, name)
+	}
+	if !needsValues(stats) {
+		t.Fatalf("expected robust statistics to need the values")
+	}
+
+	var acc fieldstats.Accumulator
+	for _, v := range data {
+		acc.Add(v)
+	}
+
+	actual := calculateStats(&acc, data, stats, 0)
+	for name, want := range expected {
+		if math.Abs(actual[name]-want) > 1e-12 {
+			t.Errorf("expected %s %v, actual %v", name, want, actual[name])
+		}
+	}
+}
+
+func TestEndBatchRobustZScore(t *testing.T) {
+	ch := make(chan *agent.Response, 12)
+	sm := newCalcMeanStddev(&agent.Agent{Responses: ch})
+	initHandler(t, sm,
+		stringOption("field", "cpu"),
+		stringOption("emit", "points"),
+		stringOption("zscore", "robust"))
+
+	// Median 5.5 and scaled MAD 2.5*1.4826 despite the spike
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 100}
+	sm.BeginBatch(&agent.BeginBatch{Name: "cpu"})
+	for _, v := range values {
+		sm.Point(&agent.Point{FieldsDouble: map[string]float64{"cpu": v}})
+	}
+	sm.EndBatch(&agent.EndBatch{Name: "cpu"})
+
+	<-ch
+	for _, v := range values {
+		p := (<-ch).Message.(*agent.Response_Point).Point
+		z := (v - 5.5) / (2.5 * madScale)
+		if p.FieldsDouble["deviation"] != v-5.5 || math.Abs(p.FieldsDouble["zscore"]-z) > 1e-12 ||
+			p.FieldsBool["is_outlier"] != (v == 100) {
+			t.Errorf("unexpected annotation of %v: %v %v", v, p.FieldsDouble, p.FieldsBool)
+		}
+	}
+}
+
+func TestInitInvalidZScore(t *testing.T) {
+	sm := newCalcMeanStddev(&agent.Agent{})
+	if init, _ := sm.Init(newInitRequest(stringOption("field", "cpu"), stringOption("zscore", "modified"))); init.Success {
+		t.Errorf("expected failure, actual success")
+	}
+}
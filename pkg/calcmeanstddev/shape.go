@@ -0,0 +1,164 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+
+	"pkg/fieldstats"
+	"pkg/utils"
+)
+
+// What to do at the end of a batch, or sub-group, without results: send
+// nothing, or a point with the count of the values and the sentinel value
+// for the other statistics, so that no data can be told from no job
+// downstream.
+const (
+	emptyBatchSkip = "skip"
+	emptyBatchEmit = "emit"
+)
+
+// shape is how the summary points are shaped: their measurement, the
+// prefix of their fields, the static tags added, and the tags and fields
+// copied from the last point of the batch.
+type shape struct {
+	name       string
+	prefix     string
+	tags       map[string]string
+	copyTags   []string
+	copyFields []string
+
+	emptyBatch string
+	emptyValue float64
+}
+
+func parseEmptyBatch(policy string) (string, error) {
+	switch policy = strings.ToLower(strings.TrimSpace(policy)); policy {
+	case "":
+		return emptyBatchSkip, nil
+	case emptyBatchSkip, emptyBatchEmit:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("invalid 'emptyBatch' value '%s', must be 'skip' or 'emit'", policy)
+}
+
+// parseStaticTags parses the tags like "env=prod, team=ops" into tags.
+func parseStaticTags(tags map[string]string, list string) error {
+	for _, pair := range utils.SplitList(list) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+			return fmt.Errorf("invalid tag '%s', must be like 'key=value'", pair)
+		}
+		tags[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return nil
+}
+
+// apply shapes the summary point p, given the last point of the batch.
+func (sh *shape) apply(p *agent.Point, last *agent.Point) {
+	if len(sh.name) > 0 {
+		p.Name = sh.name
+	}
+
+	if len(sh.prefix) > 0 {
+		fieldsDouble := make(map[string]float64, len(p.FieldsDouble))
+		for k, v := range p.FieldsDouble {
+			fieldsDouble[sh.prefix+k] = v
+		}
+		fieldsInt := make(map[string]int64, len(p.FieldsInt))
+		for k, v := range p.FieldsInt {
+			fieldsInt[sh.prefix+k] = v
+		}
+		fieldsBool := make(map[string]bool, len(p.FieldsBool))
+		for k, v := range p.FieldsBool {
+			fieldsBool[sh.prefix+k] = v
+		}
+		p.FieldsDouble, p.FieldsInt, p.FieldsBool = fieldsDouble, fieldsInt, fieldsBool
+	}
+
+	if len(sh.tags)+len(sh.copyTags) > 0 {
+		tags := make(map[string]string, len(p.Tags)+len(sh.tags)+len(sh.copyTags))
+		for k, v := range p.Tags {
+			tags[k] = v
+		}
+		for k, v := range sh.tags {
+			tags[k] = v
+		}
+		for _, k := range sh.copyTags {
+			if v, ok := last.GetTags()[k]; ok {
+				tags[k] = v
+			}
+		}
+		p.Tags = tags
+	}
+
+	if last == nil {
+		return
+	}
+	for _, k := range sh.copyFields {
+		if v, ok := last.FieldsDouble[k]; ok {
+			p.FieldsDouble[k] = v
+		} else if v, ok := last.FieldsInt[k]; ok {
+			p.FieldsInt[k] = v
+		} else if v, ok := last.FieldsBool[k]; ok {
+			p.FieldsBool[k] = v
+		} else if v, ok := last.FieldsString[k]; ok {
+			if p.FieldsString == nil {
+				p.FieldsString = make(map[string]string)
+			}
+			p.FieldsString[k] = v
+		}
+	}
+}
+
+// emptyPoint returns the point of a batch, or of a sub-group of it,
+// without results: the real count of the values of each field, short of
+// 'minCount' if any, and the sentinel value for the other statistics.
+// The fields are the ones selected by name, or else the ones of the batch.
+func (sm *calcMeanStddev) emptyPoint(end *agent.EndBatch, a *accumulation, tags map[string]string, last *agent.Point) *agent.Point {
+	p := &agent.Point{
+		Time:         end.GetTmax(),
+		Name:         end.GetName(),
+		Group:        end.GetGroup(),
+		Tags:         tags,
+		FieldsDouble: make(map[string]float64),
+		FieldsInt:    make(map[string]int64),
+		FieldsBool:   make(map[string]bool),
+	}
+
+	fields := sm.fields.Names()
+	if len(fields) == 0 {
+		fields = sortedKeys(a.series)
+	}
+	if len(fields) == 0 {
+		p.FieldsInt["count"] = 0
+	}
+	for _, field := range fields {
+		var count int64
+		if s, ok := a.series[field]; ok {
+			count = s.acc.Count()
+		}
+		p.FieldsInt[fieldstats.OutputFieldName(sm.fieldFormat, field, "count")] = count
+
+		q := a.quality[field]
+		for _, stat := range sm.stats {
+			switch {
+			case stat == "count":
+			case stat == "missing_count" && q != nil:
+				p.FieldsInt[fieldstats.OutputFieldName(sm.fieldFormat, field, stat)] = q.missing
+			case stat == "masked_out_count" && q != nil:
+				p.FieldsInt[fieldstats.OutputFieldName(sm.fieldFormat, field, stat)] = q.maskedOut
+			case isQualityStat(stat):
+				p.FieldsInt[fieldstats.OutputFieldName(sm.fieldFormat, field, stat)] = 0
+			default:
+				p.FieldsDouble[fieldstats.OutputFieldName(sm.fieldFormat, field, stat)] = sm.shape.emptyValue
+			}
+		}
+	}
+	sm.shape.apply(p, last)
+
+	return p
+}
@@ -0,0 +1,150 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestEndBatchShape(t *testing.T) {
+	ch := make(chan *agent.Response, 1)
+	sm := newCalcMeanStddev(&agent.Agent{Responses: ch})
+	initHandler(t, sm,
+		stringOption("field", "cpu"),
+		stringOption("as", "cpu_stats"),
+		stringOption("prefix", "stat_"),
+		stringOption("tags", "env=prod, team=ops"),
+		stringOption("copyTags", "host"),
+		stringOption("copyFields", "version"))
+
+	sm.BeginBatch(&agent.BeginBatch{Name: "cpu"})
+	sm.Point(&agent.Point{Tags: map[string]string{"host": "a"}, FieldsDouble: map[string]float64{"cpu": 1}})
+	sm.Point(&agent.Point{Tags: map[string]string{"host": "b"}, FieldsDouble: map[string]float64{"cpu": 3},
+		FieldsString: map[string]string{"version": "1.2"}})
+	sm.EndBatch(&agent.EndBatch{Name: "cpu", Tags: map[string]string{"region": "eu"}})
+
+	p := (<-ch).Message.(*agent.Response_Point).Point
+	if p.Name != "cpu_stats" {
+		t.Errorf("expected measurement cpu_stats, actual %s", p.Name)
+	}
+	if expected := map[string]float64{"stat_mean": 2, "stat_stddev": 1}; !reflect.DeepEqual(expected, p.FieldsDouble) {
+		t.Errorf("expected fields %v, actual %v", expected, p.FieldsDouble)
+	}
+	if expected := map[string]string{"region": "eu", "env": "prod", "team": "ops", "host": "b"}; !reflect.DeepEqual(expected, p.Tags) {
+		t.Errorf("expected tags %v, actual %v", expected, p.Tags)
+	}
+	if p.FieldsString["version"] != "1.2" {
+		t.Errorf("expected copied field version, actual %v", p.FieldsString)
+	}
+}
+
+func TestEndBatchEmpty(t *testing.T) {
+	for _, tc := range [...]struct {
+		policy  string
+		ints    map[string]int64
+		doubles map[string]float64
+	}{
+		{"", nil, nil},
+		{"skip", nil, nil},
+		{"emit", map[string]int64{"count": 0, "missing_count": 0}, map[string]float64{"mean": -1, "stddev": -1}},
+	} {
+		t.Run(fmt.Sprintf("Empty batch '%s'", tc.policy), func(t *testing.T) {
+			ch := make(chan *agent.Response, 1)
+			sm := newCalcMeanStddev(&agent.Agent{Responses: ch})
+			initHandler(t, sm,
+				stringOption("field", "cpu"),
+				stringOption("stats", "mean,stddev,count,missing_count"),
+				stringOption("emptyBatch", tc.policy))
+
+			actual := runBatch(t, sm, nil)
+			if tc.ints == nil {
+				if actual != nil {
+					t.Errorf("expected no point, actual %v %v", actual.FieldsInt, actual.FieldsDouble)
+				}
+				return
+			}
+			if actual == nil {
+				t.Fatalf("expected a point")
+			}
+			if !reflect.DeepEqual(tc.ints, actual.FieldsInt) || !reflect.DeepEqual(tc.doubles, actual.FieldsDouble) {
+				t.Errorf("expected %v %v, actual %v %v", tc.ints, tc.doubles, actual.FieldsInt, actual.FieldsDouble)
+			}
+		})
+	}
+}
+
+func TestEndBatchEmptyMinCount(t *testing.T) {
+	sm := newCalcMeanStddev(&agent.Agent{})
+	initHandler(t, sm,
+		stringOption("field", "cpu"),
+		stringOption("stats", "mean,count"),
+		intOption("minCount", 3),
+		stringOption("emptyBatch", "emit"))
+
+	// The field short of 'minCount' has its real count
+	actual := runBatch(t, sm, []*agent.Point{
+		{FieldsDouble: map[string]float64{"cpu": 1}},
+		{FieldsDouble: map[string]float64{"cpu": 2}},
+	})
+	if actual == nil {
+		t.Fatalf("expected a point")
+	}
+	if actual.FieldsInt["count"] != 2 || actual.FieldsDouble["mean"] != -1 {
+		t.Errorf("expected count 2 and mean -1, actual %v %v", actual.FieldsInt, actual.FieldsDouble)
+	}
+}
+
+func TestEndBatchEmptyBy(t *testing.T) {
+	ch := make(chan *agent.Response, 4)
+	sm := newCalcMeanStddev(&agent.Agent{Responses: ch})
+	initHandler(t, sm,
+		stringOption("field", "cpu"),
+		stringOption("stats", "mean,count"),
+		stringOption("by", "host"),
+		stringOption("emptyBatch", "emit"))
+
+	sm.BeginBatch(&agent.BeginBatch{Name: "cpu"})
+	sm.EndBatch(&agent.EndBatch{Name: "cpu", Tags: map[string]string{"dc": "a"}})
+
+	if len(ch) != 3 {
+		t.Fatalf("expected 3 responses, actual %d", len(ch))
+	}
+	<-ch
+	p := (<-ch).Message.(*agent.Response_Point).Point
+	if p.FieldsInt["count"] != 0 || p.FieldsDouble["mean"] != -1 || p.Tags["dc"] != "a" {
+		t.Errorf("expected the empty point of the batch, actual %v", p)
+	}
+}
+
+func TestInitInvalidShape(t *testing.T) {
+	for _, opt := range []*agent.Option{
+		stringOption("tags", "env"),
+		stringOption("tags", "=prod"),
+		stringOption("emptyBatch", "zero"),
+	} {
+		t.Run(fmt.Sprintf("Init with %s", opt.Name), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{})
+			if init, _ := sm.Init(newInitRequest(stringOption("field", "cpu"), opt)); init.Success {
+				t.Errorf("expected failure, actual success")
+			}
+		})
+	}
+
+	for _, opts := range [][]*agent.Option{
+		{stringOption("emit", "points")},
+		{stringOption("histogramOutput", "points"), {
+			Name:   "histogram",
+			Values: []*agent.OptionValue{stringValue("explicit"), stringValue("1,2")},
+		}},
+	} {
+		t.Run(fmt.Sprintf("Init empty batches with %s", opts[0].Name), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{})
+			opts = append(opts, stringOption("field", "cpu"), stringOption("emptyBatch", "emit"))
+			if init, _ := sm.Init(newInitRequest(opts...)); init.Success {
+				t.Errorf("expected failure, actual success")
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pkg/fieldstats"
+)
+
+// defaultStats are the statistics calculated if the 'stats' option
+// is not supplied.
+var defaultStats = []string{"mean", "stddev"}
+
+// parseStats validates the names of statistics, which could be "mean",
+// "stddev", "median", "min", "max", "count", "sum", "range", a percentile
+// like "p90" or "p99.9", the time-weighted "twmean" and "twstddev", one
+// of the robust statistics, the data quality counts "missing_count" and
+// "masked_out_count", or the trend statistics.
+func parseStats(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return defaultStats, nil
+	}
+
+	stats := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		switch name {
+		case "mean", "stddev", "median", "min", "max", "count", "sum", "range", "twmean", "twstddev",
+			"missing_count", "masked_out_count":
+		default:
+			if isTrendStat(name) {
+				break
+			}
+			kind, _, err := parseRobust(name)
+			if err != nil {
+				return nil, err
+			}
+			if kind != "" {
+				break
+			}
+			if _, err := parsePercentile(name); err != nil {
+				return nil, err
+			}
+		}
+		stats = append(stats, name)
+	}
+
+	return stats, nil
+}
+
+func parsePercentile(name string) (float64, error) {
+	if strings.HasPrefix(name, "p") {
+		p, err := strconv.ParseFloat(name[1:], 64)
+		if err == nil && p >= 0 && p <= 100 {
+			return p, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid statistic '%s'", name)
+}
+
+// needsValues tells if any of the statistics is a median, percentile or
+// robust statistic, which needs all values rather than the accumulated
+// moments.
+func needsValues(stats []string) bool {
+	for _, name := range stats {
+		if kind, _, _ := parseRobust(name); kind != "" {
+			return true
+		}
+		if name == "median" || strings.HasPrefix(name, "p") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// calculateStats calculates the statistics from the accumulated moments,
+// and from the values for medians, percentiles and robust statistics. The
+// values are sorted in place. The stddev and winsorized stddev are left
+// out if there are not more than ddof values.
+func calculateStats(acc *fieldstats.Accumulator, values []float64, stats []string, ddof int64) map[string]float64 {
+	res := make(map[string]float64, len(stats))
+	if acc.Count() == 0 {
+		return res
+	}
+
+	isSorted := false
+
+	for _, name := range stats {
+		switch name {
+		case "mean":
+			res[name] = acc.Mean()
+		case "stddev":
+			if sd, ok := acc.Stddev(ddof); ok {
+				res[name] = sd
+			}
+		case "count":
+			res[name] = float64(acc.Count())
+		case "sum":
+			res[name] = acc.Sum()
+		case "min":
+			res[name] = acc.Min()
+		case "max":
+			res[name] = acc.Max()
+		case "range":
+			res[name] = acc.Max() - acc.Min()
+		case "twmean", "twstddev", "missing_count", "masked_out_count":
+			// Calculated by the time weighting, or counted apart
+		default:
+			if isTrendStat(name) {
+				// Calculated by the trend
+				break
+			}
+			if !isSorted {
+				sort.Float64s(values)
+				isSorted = true
+			}
+			if kind, _, _ := parseRobust(name); kind != "" {
+				if v, ok := robustStatistic(name, values, ddof); ok {
+					res[name] = v
+				}
+				break
+			}
+			res[name] = orderStatistic(name, values)
+		}
+	}
+
+	return res
+}
+
+func orderStatistic(name string, sorted []float64) float64 {
+	if name == "median" {
+		return percentile(sorted, 50)
+	}
+
+	p, _ := parsePercentile(name)
+	return percentile(sorted, p)
+}
+
+// percentile returns the p-th percentile of the sorted data by linear
+// interpolation between the closest ranks, i.e. the value at the rank
+// (n-1)*p/100 (the method R-7 which is the default of numpy and Excel's
+// PERCENTILE.INC). For example, p90 of [1, 2, 3, 4] is at the rank 2.7,
+// which is 3 + 0.7*(4-3) = 3.7.
+func percentile(sorted []float64, p float64) float64 {
+	rank := float64(len(sorted)-1) * p / 100
+	lower := math.Floor(rank)
+	i := int(lower)
+
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+
+	return sorted[i] + (rank-lower)*(sorted[i+1]-sorted[i])
+}
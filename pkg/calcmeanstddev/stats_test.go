@@ -0,0 +1,95 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"pkg/fieldstats"
+)
+
+func TestParseStats(t *testing.T) {
+	for _, tc := range [...]struct {
+		names    []string
+		expected []string
+		isErr    bool
+	}{
+		{nil, []string{"mean", "stddev"}, false},
+		{[]string{"Median", "p90", "p99.9", "min", "max", "count", "sum", "range"},
+			[]string{"median", "p90", "p99.9", "min", "max", "count", "sum", "range"}, false},
+		{[]string{"p101"}, nil, true},
+		{[]string{"mode"}, nil, true},
+		{[]string{"p"}, nil, true},
+	} {
+		t.Run(fmt.Sprintf("Parse stats %v", tc.names), func(t *testing.T) {
+			actual, err := parseStats(tc.names)
+			if (err != nil) != tc.isErr {
+				t.Fatalf("expected error %v, actual %v", tc.isErr, err)
+			}
+			if !tc.isErr && !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCalculateStats(t *testing.T) {
+	stats := []string{"mean", "stddev", "median", "p90", "p0", "p100", "min", "max", "count", "sum", "range"}
+
+	for _, tc := range [...]struct {
+		data     []float64
+		expected map[string]float64
+	}{
+		{[]float64{4, 1, 3, 2}, map[string]float64{
+			"mean": 2.5, "stddev": math.Sqrt(1.25), "median": 2.5, "p90": 3.7, "p0": 1, "p100": 4,
+			"min": 1, "max": 4, "count": 4, "sum": 10, "range": 3,
+		}},
+		{[]float64{7}, map[string]float64{
+			"mean": 7, "stddev": 0, "median": 7, "p90": 7, "p0": 7, "p100": 7,
+			"min": 7, "max": 7, "count": 1, "sum": 7, "range": 0,
+		}},
+		{[]float64{}, map[string]float64{}},
+	} {
+		t.Run(fmt.Sprintf("Calculate stats of %v", tc.data), func(t *testing.T) {
+			var acc fieldstats.Accumulator
+			for _, v := range tc.data {
+				acc.Add(v)
+			}
+
+			actual := calculateStats(&acc, tc.data, stats, 0)
+			if len(actual) != len(tc.expected) {
+				t.Fatalf("expected %v, actual %v", tc.expected, actual)
+			}
+			for name, v := range tc.expected {
+				if math.Abs(actual[name]-v) > 1e-12 {
+					t.Errorf("%s: expected %v, actual %v", name, v, actual[name])
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateStatsDdof(t *testing.T) {
+	for _, tc := range [...]struct {
+		data     []float64
+		ddof     int64
+		expected map[string]float64
+	}{
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 0, map[string]float64{"stddev": 2}},
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 1, map[string]float64{"stddev": math.Sqrt(32.0 / 7)}},
+		{[]float64{2}, 1, map[string]float64{}},
+	} {
+		t.Run(fmt.Sprintf("Calculate stddev with ddof %d", tc.ddof), func(t *testing.T) {
+			var acc fieldstats.Accumulator
+			for _, v := range tc.data {
+				acc.Add(v)
+			}
+
+			actual := calculateStats(&acc, nil, []string{"stddev"}, tc.ddof)
+			if len(actual) != len(tc.expected) || math.Abs(actual["stddev"]-tc.expected["stddev"]) > 1e-12 {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
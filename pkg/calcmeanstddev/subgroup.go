@@ -0,0 +1,156 @@
+package calcmeanstddev
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+// accumulation is what's accumulated by field, of the batch or of a
+// sub-group of it, and what's not.
+type accumulation struct {
+	series      map[string]*series
+	quality     map[string]*fieldQuality
+	weighting   *timeWeighting
+	correlation *correlation
+
+	// The EWMA results of the batch, once folded into the baselines
+	ewma map[string]ewmaResult
+}
+
+func (sm *calcMeanStddev) newAccumulation() *accumulation {
+	return &accumulation{
+		series:      make(map[string]*series),
+		quality:     make(map[string]*fieldQuality),
+		weighting:   sm.weighting.fork(),
+		correlation: sm.correlation.fork(),
+	}
+}
+
+func (a *accumulation) fieldQuality(name string) *fieldQuality {
+	q, ok := a.quality[name]
+	if !ok {
+		q = &fieldQuality{}
+		a.quality[name] = q
+	}
+
+	return q
+}
+
+// addQuality counts the fields of a point which are masked out, or
+// missing from a point which is not.
+func (a *accumulation) addQuality(values map[string]float64, missing []string, matched bool) {
+	if matched {
+		for _, name := range missing {
+			a.fieldQuality(name).missing++
+		}
+		return
+	}
+
+	for name := range values {
+		a.fieldQuality(name).maskedOut++
+	}
+	for _, name := range missing {
+		if _, ok := values[name]; !ok {
+			a.fieldQuality(name).maskedOut++
+		}
+	}
+}
+
+// hasMinCount tells if enough values of the field are accumulated to
+// send its results.
+func (a *accumulation) hasMinCount(field string, minCount int64) bool {
+	if minCount == 0 {
+		return true
+	}
+
+	s, ok := a.series[field]
+	return ok && s.acc.Count() >= minCount
+}
+
+// subgroup is the points of the batch with the same values of the tags
+// of the 'by' option.
+type subgroup struct {
+	tags map[string]string
+	acc  *accumulation
+	last *agent.Point
+}
+
+// subgroup returns the sub-group of the point p, or nil if there is no
+// 'by' option. A tag missing from the point has the empty value.
+func (sm *calcMeanStddev) subgroup(p *agent.Point) *subgroup {
+	if len(sm.by) == 0 {
+		return nil
+	}
+
+	values := make([]string, len(sm.by))
+	for i, key := range sm.by {
+		values[i] = p.Tags[key]
+	}
+	key := strings.Join(values, "\x00")
+
+	sub, ok := sm.subgroups[key]
+	if !ok {
+		sub = &subgroup{
+			tags: make(map[string]string, len(sm.by)),
+			acc:  sm.newAccumulation(),
+		}
+		for i, k := range sm.by {
+			sub.tags[k] = values[i]
+		}
+		sm.subgroups[key] = sub
+	}
+
+	return sub
+}
+
+// sendSubgroups sends a point per sub-group carrying its tags, and the
+// point of the whole batch if 'byTotal', framed by the begin and end of
+// the batch.
+func (sm *calcMeanStddev) sendSubgroups(end *agent.EndBatch) {
+	keys := make([]string, 0, len(sm.subgroups))
+	for key := range sm.subgroups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var points []*agent.Point
+	for _, key := range keys {
+		sub := sm.subgroups[key]
+
+		tags := make(map[string]string, len(end.GetTags())+len(sub.tags))
+		for k, v := range end.GetTags() {
+			tags[k] = v
+		}
+		for k, v := range sub.tags {
+			tags[k] = v
+		}
+
+		p := sm.summaryPoint(end, sub.acc, tags, sub.last)
+		if p == nil && sm.shape.emptyBatch == emptyBatchEmit {
+			p = sm.emptyPoint(end, sub.acc, tags, sub.last)
+		}
+		if p != nil {
+			points = append(points, p)
+		}
+	}
+
+	// A batch without points has no sub-groups, so its empty point is of
+	// the whole batch
+	if sm.byTotal || (len(sm.subgroups) == 0 && sm.shape.emptyBatch == emptyBatchEmit) {
+		p := sm.summaryPoint(end, sm.total, end.GetTags(), sm.last)
+		if p == nil && sm.shape.emptyBatch == emptyBatchEmit {
+			p = sm.emptyPoint(end, sm.total, end.GetTags(), sm.last)
+		}
+		if p != nil {
+			points = append(points, p)
+		}
+	}
+
+	if len(points) == 0 {
+		return
+	}
+
+	sm.sendBatch(end, points)
+}
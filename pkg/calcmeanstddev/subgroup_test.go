@@ -0,0 +1,98 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestEndBatchBy(t *testing.T) {
+	points := []*agent.Point{
+		{Tags: map[string]string{"host": "a", "cpu": "0"}, FieldsDouble: map[string]float64{"usage": 1}},
+		{Tags: map[string]string{"host": "b", "cpu": "0"}, FieldsDouble: map[string]float64{"usage": 10}},
+		{Tags: map[string]string{"host": "a", "cpu": "1"}, FieldsDouble: map[string]float64{"usage": 3}},
+		{Tags: map[string]string{"cpu": "0"}, FieldsDouble: map[string]float64{"usage": 6}},
+		{Tags: map[string]string{"host": "b", "cpu": "1"}, FieldsDouble: map[string]float64{"usage": 20}},
+	}
+
+	for _, tc := range [...]struct {
+		byTotal  bool
+		expected []map[string]string
+		means    []float64
+	}{
+		{false, []map[string]string{
+			{"region": "eu", "host": ""},
+			{"region": "eu", "host": "a"},
+			{"region": "eu", "host": "b"},
+		}, []float64{6, 2, 15}},
+		{true, []map[string]string{
+			{"region": "eu", "host": ""},
+			{"region": "eu", "host": "a"},
+			{"region": "eu", "host": "b"},
+			{"region": "eu"},
+		}, []float64{6, 2, 15, 8}},
+	} {
+		t.Run(fmt.Sprintf("By host with total %v", tc.byTotal), func(t *testing.T) {
+			ch := make(chan *agent.Response, 8)
+			sm := newCalcMeanStddev(&agent.Agent{Responses: ch})
+			initHandler(t, sm,
+				stringOption("field", "usage"),
+				stringOption("stats", "mean"),
+				stringOption("by", "host"),
+				&agent.Option{
+					Name:   "byTotal",
+					Values: []*agent.OptionValue{{Type: agent.ValueType_BOOL, Value: &agent.OptionValue_BoolValue{BoolValue: tc.byTotal}}},
+				})
+
+			sm.BeginBatch(&agent.BeginBatch{Name: "cpu"})
+			for _, p := range points {
+				sm.Point(p)
+			}
+			sm.EndBatch(&agent.EndBatch{Name: "cpu", Group: "region=eu", Tags: map[string]string{"region": "eu"}})
+
+			if len(ch) != len(tc.expected)+2 {
+				t.Fatalf("expected %d responses, actual %d", len(tc.expected)+2, len(ch))
+			}
+			begin := (<-ch).Message.(*agent.Response_Begin).Begin
+			if begin.Size != int64(len(tc.expected)) {
+				t.Errorf("expected batch of %d points, actual %d", len(tc.expected), begin.Size)
+			}
+			for i, tags := range tc.expected {
+				p := (<-ch).Message.(*agent.Response_Point).Point
+				if !reflect.DeepEqual(tags, p.Tags) || p.FieldsDouble["mean"] != tc.means[i] || p.Group != "region=eu" {
+					t.Errorf("expected %v with mean %v, actual %v %v", tags, tc.means[i], p.Tags, p.FieldsDouble)
+				}
+			}
+			if _, ok := (<-ch).Message.(*agent.Response_End); !ok {
+				t.Errorf("expected end of batch")
+			}
+		})
+	}
+}
+
+func TestInitInvalidBy(t *testing.T) {
+	for _, tc := range [...]struct {
+		name string
+		opt  *agent.Option
+	}{
+		{"emit points", stringOption("emit", "points")},
+		{"histogram points", stringOption("histogramOutput", "points")},
+	} {
+		t.Run(fmt.Sprintf("Init 'by' with %s", tc.name), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{})
+			init, _ := sm.Init(newInitRequest(
+				stringOption("field", "usage"),
+				stringOption("by", "host"),
+				&agent.Option{
+					Name:   "histogram",
+					Values: []*agent.OptionValue{stringValue("explicit"), stringValue("1,2")},
+				},
+				tc.opt))
+			if init.Success {
+				t.Errorf("expected failure, actual success")
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package calcmeanstddev
+
+import (
+	"math"
+	"time"
+)
+
+// The statistics of the least-squares line of the values against the
+// point time.
+var trendStats = []string{"slope", "intercept", "r2", "residual_stddev", "forecast", "time_to_threshold"}
+
+func isTrendStat(name string) bool {
+	for _, s := range trendStats {
+		if s == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func needsTrend(stats []string) bool {
+	for _, name := range stats {
+		if isTrendStat(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trend accumulates the co-moments of the values against their time in
+// seconds since the first point, updated like the Welford variance so
+// that nanosecond epochs don't cost the precision.
+type trend struct {
+	n   int64
+	t0  int64
+	mx  float64
+	my  float64
+	sxx float64
+	syy float64
+	sxy float64
+}
+
+func (tr *trend) add(t int64, y float64) {
+	if tr.n == 0 {
+		tr.t0 = t
+	}
+	x := float64(t-tr.t0) / float64(time.Second)
+
+	tr.n++
+	dx := x - tr.mx
+	dy := y - tr.my
+	tr.mx += dx / float64(tr.n)
+	tr.my += dy / float64(tr.n)
+	tr.sxx += dx * (x - tr.mx)
+	tr.syy += dy * (y - tr.my)
+	tr.sxy += dx * (y - tr.my)
+}
+
+// trendOptions are how the trend statistics are reported: the slope and
+// the time to the threshold in the unit, and the forecast at the horizon
+// after Tmax.
+type trendOptions struct {
+	unit         int64
+	horizon      int64
+	threshold    float64
+	hasThreshold bool
+}
+
+// calculate returns the trend statistics of the line at Tmax, which is
+// the origin of the intercept. Nothing is returned for fewer than two
+// points at different times. The r² is left out if the values are all
+// the same, the residual stddev for fewer than three points, and the time
+// to the threshold if the line doesn't cross it after Tmax.
+func (tr *trend) calculate(tmax int64, stats []string, opts trendOptions) map[string]float64 {
+	res := make(map[string]float64)
+	if tr.n < 2 || tr.sxx <= 0 {
+		return res
+	}
+
+	slope := tr.sxy / tr.sxx
+	at := func(t int64) float64 {
+		return tr.my + slope*(float64(t-tr.t0)/float64(time.Second)-tr.mx)
+	}
+	unit := float64(opts.unit) / float64(time.Second)
+
+	for _, name := range stats {
+		switch name {
+		case "slope":
+			res[name] = slope * unit
+		case "intercept":
+			res[name] = at(tmax)
+		case "r2":
+			if tr.syy > 0 {
+				res[name] = tr.sxy * tr.sxy / (tr.sxx * tr.syy)
+			}
+		case "residual_stddev":
+			if tr.n > 2 {
+				sse := tr.syy - tr.sxy*tr.sxy/tr.sxx
+				res[name] = math.Sqrt(math.Max(sse, 0) / float64(tr.n-2))
+			}
+		case "forecast":
+			res[name] = at(tmax + opts.horizon)
+		case "time_to_threshold":
+			if opts.hasThreshold && slope != 0 {
+				if secs := (opts.threshold - at(tmax)) / slope; secs >= 0 {
+					res[name] = secs / unit
+				}
+			}
+		}
+	}
+
+	return res
+}
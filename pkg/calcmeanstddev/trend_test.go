@@ -0,0 +1,79 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestEndBatchTrend(t *testing.T) {
+	// Hourly points till the Tmax at 03:15
+	tmax := time.Date(2019, 8, 26, 3, 15, 0, 0, time.UTC)
+	durationOption := func(name string, d time.Duration) *agent.Option {
+		return &agent.Option{
+			Name:   name,
+			Values: []*agent.OptionValue{{Type: agent.ValueType_DURATION, Value: &agent.OptionValue_DurationValue{DurationValue: int64(d)}}},
+		}
+	}
+
+	for _, tc := range [...]struct {
+		values    []float64
+		threshold float64
+		expected  map[string]float64
+	}{
+		{[]float64{10, 12, 14, 16}, 20, map[string]float64{
+			"slope": 2, "intercept": 16, "r2": 1, "residual_stddev": 0, "forecast": 64, "time_to_threshold": 2,
+		}},
+		{[]float64{10, 14, 12, 16}, 10, map[string]float64{
+			"slope": 1.6, "intercept": 15.4, "r2": 0.64, "residual_stddev": math.Sqrt(3.6), "forecast": 15.4 + 1.6*24,
+		}},
+		{[]float64{5, 5, 5, 5}, 10, map[string]float64{
+			"slope": 0, "intercept": 5, "residual_stddev": 0, "forecast": 5,
+		}},
+	} {
+		t.Run(fmt.Sprintf("Trend of %v", tc.values), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+			initHandler(t, sm,
+				stringOption("field", "disk"),
+				stringOption("stats", "slope,intercept,r2,residual_stddev,forecast,time_to_threshold"),
+				durationOption("trendUnit", time.Hour),
+				durationOption("horizon", 24*time.Hour),
+				&agent.Option{
+					Name:   "trendThreshold",
+					Values: []*agent.OptionValue{{Type: agent.ValueType_DOUBLE, Value: &agent.OptionValue_DoubleValue{DoubleValue: tc.threshold}}},
+				})
+
+			var points []*agent.Point
+			for i, v := range tc.values {
+				points = append(points, &agent.Point{
+					Time:         tmax.Add(time.Duration(i-len(tc.values)+1) * time.Hour).UnixNano(),
+					FieldsDouble: map[string]float64{"disk": v},
+				})
+			}
+
+			actual := runBatch(t, sm, points)
+			if len(actual.FieldsDouble) != len(tc.expected) {
+				t.Fatalf("expected %v, actual %v", tc.expected, actual.FieldsDouble)
+			}
+			for name, want := range tc.expected {
+				if math.Abs(actual.FieldsDouble[name]-want) > 1e-9 {
+					t.Errorf("expected %s %v, actual %v", name, want, actual.FieldsDouble[name])
+				}
+			}
+		})
+	}
+}
+
+func TestInitInvalidTrend(t *testing.T) {
+	for _, stats := range []string{"forecast", "time_to_threshold"} {
+		t.Run(fmt.Sprintf("Stats %s without options", stats), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{})
+			if init, _ := sm.Init(newInitRequest(stringOption("field", "disk"), stringOption("stats", stats))); init.Success {
+				t.Errorf("expected failure, actual success")
+			}
+		})
+	}
+}
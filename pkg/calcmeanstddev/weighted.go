@@ -0,0 +1,169 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// timeWeighting calculates the time-weighted mean and stddev ("twmean"
+// and "twstddev"), for series which report on change so that a value held
+// for an hour weighs more than one held for a second.
+//
+// Between two points, the value either holds the earlier value ("step")
+// or moves linearly to the later one ("linear"). The last value holds
+// till the Tmax of the batch, and the first one from the Tmin, which is
+// Tmax minus the period if supplied, or otherwise the first point time.
+// The interval after a point only counts if the point matches the time
+// mask, though every point bounds the intervals of the others.
+type timeWeighting struct {
+	interpolation string
+	period        int64
+
+	samples map[string][]weightedSample
+}
+
+type weightedSample struct {
+	t       int64
+	v       float64
+	matched bool
+}
+
+// weightedResult is the time-weighted mean and stddev of a field.
+type weightedResult struct {
+	mean   float64
+	stddev float64
+}
+
+func newTimeWeighting(stats []string, interpolation string, period int64) (*timeWeighting, error) {
+	interpolation = strings.ToLower(strings.TrimSpace(interpolation))
+	switch interpolation {
+	case "":
+		interpolation = "step"
+	case "step", "linear":
+	default:
+		return nil, fmt.Errorf("invalid 'interpolation' '%s', must be 'step' or 'linear'", interpolation)
+	}
+
+	if period < 0 {
+		return nil, fmt.Errorf("'period' must not be negative")
+	}
+
+	for _, name := range stats {
+		if name == "twmean" || name == "twstddev" {
+			return &timeWeighting{
+				interpolation: interpolation,
+				period:        period,
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// fork returns a time weighting of the same settings without samples,
+// or nil for nil.
+func (w *timeWeighting) fork() *timeWeighting {
+	if w == nil {
+		return nil
+	}
+
+	return &timeWeighting{
+		interpolation: w.interpolation,
+		period:        w.period,
+		samples:       make(map[string][]weightedSample),
+	}
+}
+
+func (w *timeWeighting) add(t int64, values map[string]float64, matched bool) {
+	for name, v := range values {
+		w.samples[name] = append(w.samples[name], weightedSample{t: t, v: v, matched: matched})
+	}
+}
+
+// calculate returns the time-weighted mean and stddev of each field
+// which has any matched interval, given the Tmax of the batch.
+func (w *timeWeighting) calculate(tmax int64) map[string]weightedResult {
+	res := make(map[string]weightedResult)
+
+	for name, samples := range w.samples {
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].t < samples[j].t })
+
+		tmin := samples[0].t
+		if w.period > 0 {
+			tmin = tmax - w.period
+		}
+
+		if r, ok := w.integrate(samples, tmin, tmax); ok {
+			res[name] = r
+		}
+	}
+
+	return res
+}
+
+func (w *timeWeighting) integrate(samples []weightedSample, tmin int64, tmax int64) (weightedResult, bool) {
+	// Integrate the values shifted by the first one, and their squares,
+	// over the matched intervals in seconds
+	shift := samples[0].v
+	var duration, s1, s2 float64
+
+	addSegment := func(from, to int64, a, b float64) {
+		if from < tmin {
+			// Clip the segment at tmin, interpolating the value there
+			if to <= tmin {
+				return
+			}
+			a += (b - a) * float64(tmin-from) / float64(to-from)
+			from = tmin
+		}
+		if to > tmax {
+			if from >= tmax {
+				return
+			}
+			b = a + (b-a)*float64(tmax-from)/float64(to-from)
+			to = tmax
+		}
+
+		dt := float64(to-from) / 1e9
+		a, b = a-shift, b-shift
+		duration += dt
+		s1 += dt * (a + b) / 2
+		s2 += dt * (a*a + a*b + b*b) / 3
+	}
+
+	first := samples[0]
+	if first.matched && first.t > tmin {
+		addSegment(tmin, first.t, first.v, first.v)
+	}
+
+	for i, s := range samples {
+		if !s.matched {
+			continue
+		}
+
+		if i+1 < len(samples) {
+			next := samples[i+1]
+			end := next.v
+			if w.interpolation == "step" {
+				end = s.v
+			}
+			addSegment(s.t, next.t, s.v, end)
+		} else if s.t < tmax {
+			addSegment(s.t, tmax, s.v, s.v)
+		}
+	}
+
+	if duration <= 0 {
+		return weightedResult{}, false
+	}
+
+	m := s1 / duration
+	va := s2/duration - m*m
+	if va < 0 {
+		va = 0
+	}
+
+	return weightedResult{mean: m + shift, stddev: math.Sqrt(va)}, true
+}
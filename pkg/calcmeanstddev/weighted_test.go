@@ -0,0 +1,83 @@
+package calcmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestEndBatchTimeWeighted(t *testing.T) {
+	// The batch ends at 03:15, the value is 0 from 02:15, 10 from 02:45
+	// and 4 from 03:00
+	tmax := time.Date(2019, 8, 26, 3, 15, 0, 0, time.UTC)
+	points := []*agent.Point{
+		{Time: tmax.Add(-time.Hour).UnixNano(), FieldsDouble: map[string]float64{"cpu": 0}},
+		{Time: tmax.Add(-30 * time.Minute).UnixNano(), FieldsDouble: map[string]float64{"cpu": 10}},
+		{Time: tmax.Add(-15 * time.Minute).UnixNano(), FieldsDouble: map[string]float64{"cpu": 4}},
+	}
+
+	for _, tc := range [...]struct {
+		interpolation string
+		period        time.Duration
+		timeFilter    string
+		mean          float64
+		stddev        float64
+	}{
+		{"", 0, "", 3.5, math.Sqrt(16.75)},
+		{"step", 0, "", 3.5, math.Sqrt(16.75)},
+		{"linear", 0, "", 5.25, math.Sqrt(2020.0/60 - 5.25*5.25)},
+		{"step", 90 * time.Minute, "", 210.0 / 90, math.Sqrt(1740.0/90 - 210.0/90*210.0/90)},
+		{"step", 0, "m!=45", 60.0 / 45, math.Sqrt(240.0/45 - 60.0/45*60.0/45)},
+	} {
+		t.Run(fmt.Sprintf("Interpolation '%s' period %v mask '%s'", tc.interpolation, tc.period, tc.timeFilter), func(t *testing.T) {
+			sm := newCalcMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+			opts := []*agent.Option{
+				stringOption("field", "cpu"),
+				stringOption("stats", "twmean,twstddev"),
+				stringOption("interpolation", tc.interpolation),
+			}
+			if tc.period > 0 {
+				opts = append(opts, &agent.Option{
+					Name: "period",
+					Values: []*agent.OptionValue{{
+						Type:  agent.ValueType_DURATION,
+						Value: &agent.OptionValue_DurationValue{DurationValue: int64(tc.period)},
+					}},
+				})
+			}
+			if tc.timeFilter != "" {
+				opts = append(opts, &agent.Option{
+					Name:   "timeFilter",
+					Values: []*agent.OptionValue{stringValue(tc.timeFilter), stringValue("UTC")},
+				})
+			}
+			initHandler(t, sm, opts...)
+
+			actual := runBatch(t, sm, points)
+			if actual == nil {
+				t.Fatalf("expected a point")
+			}
+			if math.Abs(actual.FieldsDouble["twmean"]-tc.mean) > 1e-9 {
+				t.Errorf("expected twmean %v, actual %v", tc.mean, actual.FieldsDouble["twmean"])
+			}
+			if math.Abs(actual.FieldsDouble["twstddev"]-tc.stddev) > 1e-9 {
+				t.Errorf("expected twstddev %v, actual %v", tc.stddev, actual.FieldsDouble["twstddev"])
+			}
+		})
+	}
+}
+
+func TestInitInvalidInterpolation(t *testing.T) {
+	sm := newCalcMeanStddev(&agent.Agent{})
+	init, _ := sm.Init(newInitRequest(
+		stringOption("field", "cpu"),
+		stringOption("stats", "twmean"),
+		stringOption("interpolation", "cubic"),
+	))
+	if init.Success {
+		t.Errorf("expected failure for interpolation 'cubic'")
+	}
+}
@@ -0,0 +1,346 @@
+package changepoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+
+	"pkg/fieldstats"
+	"pkg/matchtime"
+)
+
+// The methods of change-point detection. CUSUM measures the deviations
+// from the mean of the first points of a regime, Page-Hinkley from the
+// running mean of the regime.
+const (
+	methodCUSUM       = "cusum"
+	methodPageHinkley = "pagehinkley"
+)
+
+// changePoint detects shifts of the level of the fields per group of a
+// stream. The deviations from the mean of the regime beyond the drift are
+// summed up, separately for upward and downward shifts, and a shift is
+// detected when either sum exceeds the threshold. It then sends an event
+// with the estimated time of the change, which is when the sum last
+// started from 0, the means before and after it, and the direction.
+type changePoint struct {
+	fieldstats.Options
+
+	method    string
+	drift     float64
+	threshold float64
+	warmup    int64 // points of a regime before detecting a change
+
+	groups map[string]*cpGroup
+
+	agent *agent.Agent
+}
+
+// cpGroup is the detectors of the fields of a group.
+type cpGroup struct {
+	Detectors map[string]*cpDetector `json:"detectors"`
+}
+
+// cpDetector is the state of the regime of a field since the last change.
+type cpDetector struct {
+	N   int64   `json:"n"`
+	Sum float64 `json:"sum"`
+	Ref float64 `json:"ref"` // the mean of the warmup for CUSUM
+
+	Up   cpSide `json:"up"`
+	Down cpSide `json:"down"`
+}
+
+// cpSide is the sum of the deviations in a direction, and the points
+// since it last started from 0.
+type cpSide struct {
+	S     float64 `json:"s"`
+	Start int64   `json:"start"`
+	N     int64   `json:"n"`
+	Sum   float64 `json:"sum"`
+}
+
+// cpEvent is a detected change of a field.
+type cpEvent struct {
+	time     int64
+	preMean  float64
+	postMean float64
+	up       bool
+}
+
+func newChangePoint(agent *agent.Agent) *changePoint {
+	return &changePoint{
+		agent:  agent,
+		groups: make(map[string]*cpGroup),
+	}
+}
+
+// Return the InfoResponse. Describing the properties of this UDF agent.
+func (*changePoint) Info() (*agent.InfoResponse, error) {
+	info := &agent.InfoResponse{
+		Wants:    agent.EdgeType_STREAM,
+		Provides: agent.EdgeType_STREAM,
+
+		Options: map[string]*agent.OptionInfo{
+			"timeFilter":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_STRING}},
+			"field":       {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"fieldFormat": {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"method":      {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"drift":       {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+			"threshold":   {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+			"warmup":      {ValueTypes: []agent.ValueType{agent.ValueType_INT}},
+		},
+	}
+
+	return info, nil
+}
+
+// Initialze the handler based of the provided options.
+func (cp *changePoint) Init(r *agent.InitRequest) (*agent.InitResponse, error) {
+	init := &agent.InitResponse{
+		Success: true,
+		Error:   "",
+	}
+
+	cp.warmup = 5
+
+	for _, opt := range r.Options {
+		if cp.Parse(opt) {
+			continue
+		}
+
+		switch opt.Name {
+		case "method":
+			cp.method = strings.ToLower(strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue))
+		case "drift":
+			cp.drift = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		case "threshold":
+			cp.threshold = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		case "warmup":
+			cp.warmup = opt.Values[0].Value.(*agent.OptionValue_IntValue).IntValue
+		}
+	}
+
+	if err := cp.Validate("changepoint"); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	switch cp.method {
+	case "":
+		cp.method = methodCUSUM
+	case methodCUSUM, methodPageHinkley:
+	default:
+		init.Success = false
+		init.Error = fmt.Sprintf("invalid 'method' '%s', must be 'cusum' or 'pagehinkley'", cp.method)
+		return init, nil
+	}
+
+	if cp.threshold <= 0 || cp.drift < 0 || cp.warmup <= 0 {
+		init.Success = false
+		init.Error = "'threshold' and 'warmup' must be positive and 'drift' must not be negative"
+	}
+
+	return init, nil
+}
+
+// Create a snapshot of the running state of the process.
+func (cp *changePoint) Snapshot() (*agent.SnapshotResponse, error) {
+	data, err := json.Marshal(cp.groups)
+	if err != nil {
+		return nil, err
+	}
+
+	return &agent.SnapshotResponse{
+		Snapshot: data,
+	}, nil
+}
+
+// Restore a previous snapshot.
+func (cp *changePoint) Restore(req *agent.RestoreRequest) (*agent.RestoreResponse, error) {
+	groups := make(map[string]*cpGroup)
+	if len(req.Snapshot) > 0 {
+		if err := json.Unmarshal(req.Snapshot, &groups); err != nil {
+			return &agent.RestoreResponse{
+				Success: false,
+				Error:   "failed to restore snapshot: " + err.Error(),
+			}, nil
+		}
+	}
+
+	// A null group or detector is dropped, like those never seen
+	for key, g := range groups {
+		if g == nil {
+			delete(groups, key)
+			continue
+		}
+		if g.Detectors == nil {
+			g.Detectors = make(map[string]*cpDetector)
+		}
+		for field, d := range g.Detectors {
+			if d == nil {
+				delete(g.Detectors, field)
+			}
+		}
+	}
+	cp.groups = groups
+
+	return &agent.RestoreResponse{
+		Success: true,
+	}, nil
+}
+
+// A stream has no batches
+func (*changePoint) BeginBatch(begin *agent.BeginBatch) error {
+	return nil
+}
+
+func (cp *changePoint) Point(p *agent.Point) error {
+	// Only process data points that match time mask
+	dt, _ := cp.TimeZone.In(p.GetTime(), p)
+	if len(cp.TimeMask) > 0 && !matchtime.MatchTimeWithMask(cp.TimeMask, &dt) {
+		return nil
+	}
+
+	g, ok := cp.groups[p.GetGroup()]
+	if !ok {
+		g = &cpGroup{Detectors: make(map[string]*cpDetector)}
+		cp.groups[p.GetGroup()] = g
+	}
+
+	events := make(map[string]cpEvent)
+	for field, val := range cp.Fields.Values(p) {
+		d, ok := g.Detectors[field]
+		if !ok {
+			d = &cpDetector{}
+			g.Detectors[field] = d
+		}
+
+		if e, ok := d.add(p.GetTime(), val, cp); ok {
+			events[field] = e
+		}
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	// Send the event with the point's time and tags
+	out := &agent.Point{
+		Time:            p.GetTime(),
+		Name:            p.GetName(),
+		Database:        p.GetDatabase(),
+		RetentionPolicy: p.GetRetentionPolicy(),
+		Group:           p.GetGroup(),
+		Dimensions:      p.GetDimensions(),
+		Tags:            p.GetTags(),
+		FieldsDouble:    make(map[string]float64),
+		FieldsInt:       make(map[string]int64),
+		FieldsString:    make(map[string]string),
+	}
+
+	for field, e := range events {
+		direction := "down"
+		if e.up {
+			direction = "up"
+		}
+		out.FieldsInt[fieldstats.OutputFieldName(cp.FieldFormat, field, "change_time")] = e.time
+		out.FieldsDouble[fieldstats.OutputFieldName(cp.FieldFormat, field, "pre_mean")] = e.preMean
+		out.FieldsDouble[fieldstats.OutputFieldName(cp.FieldFormat, field, "post_mean")] = e.postMean
+		out.FieldsString[fieldstats.OutputFieldName(cp.FieldFormat, field, "direction")] = direction
+	}
+
+	cp.agent.Responses <- &agent.Response{
+		Message: &agent.Response_Point{
+			Point: out,
+		},
+	}
+
+	return nil
+}
+
+// add adds the value at the time t to the regime, and tells if it makes
+// a change. On a change, the points since its time start a new regime.
+func (d *cpDetector) add(t int64, x float64, cp *changePoint) (cpEvent, bool) {
+	d.N++
+	d.Sum += x
+
+	if d.N <= cp.warmup {
+		// CUSUM measures from the mean of the warmup
+		d.Ref = d.Sum / float64(d.N)
+		return cpEvent{}, false
+	}
+
+	ref := d.Ref
+	if cp.method == methodPageHinkley {
+		ref = d.Sum / float64(d.N)
+	}
+
+	d.Up.add(t, x, x-ref-cp.drift)
+	d.Down.add(t, x, ref-x-cp.drift)
+
+	var side *cpSide
+	up := d.Up.S >= d.Down.S
+	if up && d.Up.S > cp.threshold {
+		side = &d.Up
+	} else if !up && d.Down.S > cp.threshold {
+		side = &d.Down
+	} else {
+		return cpEvent{}, false
+	}
+
+	e := cpEvent{
+		time:     side.Start,
+		preMean:  (d.Sum - side.Sum) / float64(d.N-side.N),
+		postMean: side.Sum / float64(side.N),
+		up:       up,
+	}
+
+	*d = cpDetector{N: side.N, Sum: side.Sum, Ref: e.postMean}
+	return e, true
+}
+
+// add sums up the deviation of the value x at the time t, starting from
+// 0 again whenever the sum drops to it.
+func (s *cpSide) add(t int64, x float64, deviation float64) {
+	s.S += deviation
+	if s.S <= 0 {
+		*s = cpSide{}
+		return
+	}
+
+	if s.N == 0 {
+		s.Start = t
+	}
+	s.N++
+	s.Sum += x
+}
+
+// A stream has no batches
+func (*changePoint) EndBatch(end *agent.EndBatch) error {
+	return nil
+}
+
+// Stop the handler gracefully.
+func (cp *changePoint) Stop() {
+	close(cp.agent.Responses)
+}
+
+// Start is the entry point to start the change-point UDF
+func Start() {
+	a := agent.New(os.Stdin, os.Stdout)
+	h := newChangePoint(a)
+	a.Handler = h
+
+	log.Println("Starting agent 'changepoint'")
+	a.Start()
+	err := a.Wait()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
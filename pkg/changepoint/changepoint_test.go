@@ -0,0 +1,139 @@
+package changepoint
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestDetect(t *testing.T) {
+	start := time.Date(2019, 8, 26, 0, 0, 0, 0, time.UTC)
+	at := func(i int) int64 { return start.Add(time.Duration(i) * time.Minute).UnixNano() }
+
+	for _, tc := range [...]struct {
+		method    string
+		values    []float64
+		detected  int // the index of the point detecting the change
+		changed   int // the index of the point of the change
+		direction string
+		postMean  float64
+	}{
+		{"cusum", []float64{10, 10, 10, 10, 10, 10, 10, 10, 15, 15, 15, 15}, 10, 8, "up", 15},
+		{"pagehinkley", []float64{10, 10, 10, 10, 10, 5, 5, 5, 5, 5}, 8, 5, "down", 5},
+	} {
+		t.Run(fmt.Sprintf("Method %s", tc.method), func(t *testing.T) {
+			ch := make(chan *agent.Response, 4)
+			cp := newChangePoint(&agent.Agent{Responses: ch})
+			init, _ := cp.Init(newInitRequest(
+				stringOption("field", "latency"),
+				stringOption("method", tc.method),
+				&agent.Option{
+					Name:   "drift",
+					Values: []*agent.OptionValue{{Type: agent.ValueType_DOUBLE, Value: &agent.OptionValue_DoubleValue{DoubleValue: 0.5}}},
+				},
+				&agent.Option{
+					Name:   "threshold",
+					Values: []*agent.OptionValue{{Type: agent.ValueType_DOUBLE, Value: &agent.OptionValue_DoubleValue{DoubleValue: 10}}},
+				},
+				&agent.Option{
+					Name:   "timeFilter",
+					Values: []*agent.OptionValue{stringValue("h!=3"), stringValue("UTC")},
+				}))
+			if !init.Success {
+				t.Fatalf("unexpected init failure %v", init.Error)
+			}
+
+			// The spike off hours is masked out
+			cp.Point(&agent.Point{Time: start.Add(3 * time.Hour).UnixNano(), Group: "host=a", FieldsDouble: map[string]float64{"latency": 1000}})
+
+			for i, v := range tc.values {
+				cp.Point(&agent.Point{Time: at(i), Group: "host=a", FieldsDouble: map[string]float64{"latency": v}})
+				if i < tc.detected && len(ch) > 0 {
+					t.Fatalf("unexpected change at point %d", i)
+				}
+				if i == tc.detected {
+					break
+				}
+			}
+
+			if len(ch) != 1 {
+				t.Fatalf("expected a change, actual %d responses", len(ch))
+			}
+			p := (<-ch).Message.(*agent.Response_Point).Point
+			if p.Time != at(tc.detected) || p.FieldsInt["change_time"] != at(tc.changed) ||
+				p.FieldsString["direction"] != tc.direction ||
+				p.FieldsDouble["pre_mean"] != 10 || p.FieldsDouble["post_mean"] != tc.postMean {
+				t.Errorf("unexpected change %v %v %v", p.FieldsInt, p.FieldsDouble, p.FieldsString)
+			}
+		})
+	}
+}
+
+func TestRestoreNull(t *testing.T) {
+	for _, snapshot := range []string{
+		`{"a":null}`,
+		`{"a":{"detectors":null}}`,
+		`{"a":{"detectors":{"latency":null}}}`,
+	} {
+		t.Run(fmt.Sprintf("Restore %s", snapshot), func(t *testing.T) {
+			cp := newChangePoint(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+			init, _ := cp.Init(newInitRequest(
+				stringOption("field", "latency"),
+				&agent.Option{
+					Name:   "threshold",
+					Values: []*agent.OptionValue{{Type: agent.ValueType_DOUBLE, Value: &agent.OptionValue_DoubleValue{DoubleValue: 10}}},
+				}))
+			if !init.Success {
+				t.Fatalf("unexpected init failure %v", init.Error)
+			}
+			if r, _ := cp.Restore(&agent.RestoreRequest{Snapshot: []byte(snapshot)}); !r.Success {
+				t.Fatalf("unexpected restore failure %v", r.Error)
+			}
+
+			if err := cp.Point(&agent.Point{Group: "a", FieldsDouble: map[string]float64{"latency": 1}}); err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestInvalidInit(t *testing.T) {
+	for _, opts := range [][]*agent.Option{
+		{stringOption("field", "latency")},
+		{stringOption("field", "latency"), stringOption("method", "bocpd")},
+	} {
+		t.Run(fmt.Sprintf("Init with %d options", len(opts)), func(t *testing.T) {
+			cp := newChangePoint(&agent.Agent{})
+			if init, _ := cp.Init(newInitRequest(opts...)); init.Success {
+				t.Errorf("expected failure, actual success")
+			}
+		})
+	}
+}
+
+func newInitRequest(opts ...*agent.Option) *agent.InitRequest {
+	r := &agent.InitRequest{}
+	for _, opt := range opts {
+		if opt != nil {
+			r.Options = append(r.Options, opt)
+		}
+	}
+
+	return r
+}
+
+func stringOption(name, value string) *agent.Option {
+	return &agent.Option{
+		Name:   name,
+		Values: []*agent.OptionValue{stringValue(value)},
+	}
+}
+
+func stringValue(s string) *agent.OptionValue {
+	return &agent.OptionValue{
+		Type:  agent.ValueType_STRING,
+		Value: &agent.OptionValue_StringValue{StringValue: s},
+	}
+}
@@ -0,0 +1,125 @@
+package fieldstats
+
+import (
+	"math"
+)
+
+// Accumulator calculates the moments of a stream of values in constant
+// memory and in a single pass. The variance is updated by the Welford's
+// algorithm on the values shifted by the first one, so a large offset
+// doesn't cancel out the small differences. The sum, and so the mean, is
+// compensated by the Kahan-Neumaier summation.
+type Accumulator struct {
+	n int64
+
+	shift float64 // the first value
+	xMean float64 // mean of the shifted values
+	m2    float64 // sum of squared differences from the mean
+
+	s float64
+	c float64 // compensation of the lost low-order bits of s
+
+	min float64
+	max float64
+}
+
+// Count returns the number of values.
+func (a *Accumulator) Count() int64 {
+	return a.n
+}
+
+// Min returns the smallest of the values.
+func (a *Accumulator) Min() float64 {
+	return a.min
+}
+
+// Max returns the largest of the values.
+func (a *Accumulator) Max() float64 {
+	return a.max
+}
+
+// Add adds the value v.
+func (a *Accumulator) Add(v float64) {
+	a.n++
+	if a.n == 1 {
+		a.shift = v
+		a.min, a.max = v, v
+	}
+
+	x := v - a.shift
+	delta := x - a.xMean
+	a.xMean += delta / float64(a.n)
+	a.m2 += delta * (x - a.xMean)
+
+	t := a.s + v
+	if math.Abs(a.s) >= math.Abs(v) {
+		a.c += (a.s - t) + v
+	} else {
+		a.c += (v - t) + a.s
+	}
+	a.s = t
+
+	if v < a.min {
+		a.min = v
+	}
+	if v > a.max {
+		a.max = v
+	}
+}
+
+// Remove takes out the value v which was added before, for sliding
+// windows. The min and max are not updated.
+func (a *Accumulator) Remove(v float64) {
+	if a.n <= 1 {
+		*a = Accumulator{}
+		return
+	}
+
+	x := v - a.shift
+	delta := x - a.xMean
+	a.n--
+	a.xMean -= delta / float64(a.n)
+	a.m2 -= delta * (x - a.xMean)
+	if a.m2 < 0 {
+		a.m2 = 0
+	}
+
+	t := a.s - v
+	if math.Abs(a.s) >= math.Abs(v) {
+		a.c += (a.s - t) - v
+	} else {
+		a.c += (-v - t) + a.s
+	}
+	a.s = t
+}
+
+// Sum returns the compensated sum of the values.
+func (a *Accumulator) Sum() float64 {
+	return a.s + a.c
+}
+
+// Mean returns the mean of the values.
+func (a *Accumulator) Mean() float64 {
+	if a.n == 0 {
+		return 0
+	}
+
+	return a.Sum() / float64(a.n)
+}
+
+// Variance returns the variance with the delta degrees of freedom, i.e.
+// divided by n-ddof, so that it's the population variance for 0 and the
+// sample variance for 1. It's false if there are not more than ddof values.
+func (a *Accumulator) Variance(ddof int64) (float64, bool) {
+	if a.n-ddof <= 0 {
+		return 0, false
+	}
+
+	return a.m2 / float64(a.n-ddof), true
+}
+
+// Stddev returns the standard deviation with the delta degrees of freedom.
+func (a *Accumulator) Stddev(ddof int64) (float64, bool) {
+	va, ok := a.Variance(ddof)
+	return math.Sqrt(va), ok
+}
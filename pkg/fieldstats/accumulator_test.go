@@ -0,0 +1,161 @@
+package fieldstats
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestAccumulatorAgainstReference(t *testing.T) {
+	for _, tc := range [...]struct {
+		name string
+		data []float64
+	}{
+		{"small variance on large offset", offsetSeries(1e9, 1e5)},
+		{"tiny variance on huge offset", offsetSeries(1e15, 1e4)},
+		{"mixed magnitudes", mixedSeries(1e4)},
+		{"alternating signs", alternatingSeries(1e5)},
+		{"constant", constantSeries(0.1, 1e5)},
+	} {
+		t.Run(fmt.Sprintf("Accumulate %s", tc.name), func(t *testing.T) {
+			var acc Accumulator
+			for _, v := range tc.data {
+				acc.Add(v)
+			}
+
+			mean, sum, variance := referenceMoments(tc.data)
+
+			if !closeTo(acc.Mean(), mean, 1e-15) {
+				t.Errorf("mean: expected %v, actual %v", mean, acc.Mean())
+			}
+			if !closeTo(acc.Sum(), sum, 1e-15) {
+				t.Errorf("sum: expected %v, actual %v", sum, acc.Sum())
+			}
+
+			va, _ := acc.Variance(1)
+			if !closeTo(va, variance, 1e-9) {
+				t.Errorf("variance: expected %v, actual %v", variance, va)
+			}
+		})
+	}
+}
+
+func TestAccumulatorMinMax(t *testing.T) {
+	var acc Accumulator
+	for _, v := range []float64{3, -1, 7, 2} {
+		acc.Add(v)
+	}
+
+	if acc.min != -1 || acc.max != 7 || acc.n != 4 {
+		t.Errorf("expected min -1, max 7 and n 4, actual %v, %v and %v", acc.min, acc.max, acc.n)
+	}
+
+	if _, ok := (&Accumulator{}).Variance(0); ok {
+		t.Errorf("expected no variance without values")
+	}
+}
+
+func TestAccumulatorRemove(t *testing.T) {
+	data := offsetSeries(1e9, 1000)
+
+	// Slide a window of 100 values over the data
+	var acc Accumulator
+	for i, v := range data {
+		acc.Add(v)
+		if i >= 100 {
+			acc.Remove(data[i-100])
+		}
+	}
+
+	mean, sum, variance := referenceMoments(data[len(data)-100:])
+	va, _ := acc.Variance(1)
+	if acc.n != 100 || !closeTo(acc.Mean(), mean, 1e-15) || !closeTo(acc.Sum(), sum, 1e-15) || !closeTo(va, variance, 1e-6) {
+		t.Errorf("expected n 100, mean %v, sum %v and variance %v, actual %v, %v, %v and %v",
+			mean, sum, variance, acc.n, acc.Mean(), acc.Sum(), va)
+	}
+
+	acc = Accumulator{}
+	acc.Add(3)
+	acc.Remove(3)
+	if acc.n != 0 || acc.Sum() != 0 {
+		t.Errorf("expected empty accumulator, actual %v", acc)
+	}
+}
+
+// referenceMoments calculates the mean, sum and sample variance of the
+// data in 512-bit precision by the two-pass algorithm.
+func referenceMoments(data []float64) (float64, float64, float64) {
+	const prec = 512
+
+	sum := new(big.Float).SetPrec(prec)
+	for _, v := range data {
+		sum.Add(sum, new(big.Float).SetPrec(prec).SetFloat64(v))
+	}
+
+	n := new(big.Float).SetPrec(prec).SetInt64(int64(len(data)))
+	mean := new(big.Float).SetPrec(prec).Quo(sum, n)
+
+	ss := new(big.Float).SetPrec(prec)
+	for _, v := range data {
+		d := new(big.Float).SetPrec(prec).SetFloat64(v)
+		d.Sub(d, mean)
+		ss.Add(ss, d.Mul(d, d))
+	}
+	variance := ss.Quo(ss, new(big.Float).SetPrec(prec).SetInt64(int64(len(data)-1)))
+
+	m, _ := mean.Float64()
+	s, _ := sum.Float64()
+	va, _ := variance.Float64()
+
+	return m, s, va
+}
+
+func closeTo(actual, expected, relTol float64) bool {
+	if expected == 0 {
+		return math.Abs(actual) <= relTol
+	}
+
+	return math.Abs(actual-expected) <= relTol*math.Abs(expected)
+}
+
+func offsetSeries(offset float64, n int) []float64 {
+	data := make([]float64, n)
+	for i := range data {
+		data[i] = offset + float64(i%10)*0.1
+	}
+
+	return data
+}
+
+func mixedSeries(n int) []float64 {
+	data := make([]float64, n)
+	for i := range data {
+		if i%2 == 0 {
+			data[i] = 1e10 + float64(i%7)
+		} else {
+			data[i] = 1e-3 * float64(i%5)
+		}
+	}
+
+	return data
+}
+
+func alternatingSeries(n int) []float64 {
+	data := make([]float64, n)
+	for i := range data {
+		data[i] = 1e8 * float64(1-2*(i%2))
+	}
+	data[0] += 0.5
+
+	return data
+}
+
+func constantSeries(v float64, n int) []float64 {
+	data := make([]float64, n)
+	for i := range data {
+		data[i] = v
+	}
+
+	return data
+}
@@ -0,0 +1,70 @@
+package fieldstats
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+
+	"pkg/timezone"
+	"pkg/utils"
+)
+
+// Options are the options shared by the handlers that fit the points one
+// after another, like rollingmeanstddev: the time mask and its time zone,
+// the fields and the format of the output fields.
+type Options struct {
+	TimeMask    string
+	TimeZone    *timezone.Zone
+	Fields      *Selector
+	FieldFormat string
+
+	timeZone string
+	fields   []string
+}
+
+// Parse reads the option opt, and tells if it's one of the shared options.
+func (o *Options) Parse(opt *agent.Option) bool {
+	switch opt.Name {
+	case "timeFilter":
+		o.TimeMask = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		o.timeZone = strings.TrimSpace(opt.Values[1].Value.(*agent.OptionValue_StringValue).StringValue)
+	case "field":
+		o.fields = append(o.fields, utils.SplitList(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)...)
+	case "fieldFormat":
+		o.FieldFormat = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Validate creates the field selector and the time zone of what's parsed.
+// The output fields are named "{stat}" for a single field and
+// "{field}_{stat}" otherwise, unless the format is given. 'now' is not
+// supported in the time mask of the handler 'name', as the handlers fit
+// the points one after another rather than relative to a batch.
+func (o *Options) Validate(name string) error {
+	var err error
+	if o.Fields, err = NewSelector(o.fields); err != nil {
+		return err
+	}
+
+	if len(o.FieldFormat) == 0 {
+		o.FieldFormat = "{field}_{stat}"
+		if o.Fields.IsSingle() {
+			o.FieldFormat = "{stat}"
+		}
+	}
+
+	if strings.Contains(o.TimeMask, "now") {
+		return fmt.Errorf("'now' is not supported in the 'timeFilter' of '%s'", name)
+	}
+
+	if o.TimeZone, err = timezone.NewZone(o.timeZone, "", timezone.PolicyDefault); err != nil {
+		return err
+	}
+
+	return nil
+}
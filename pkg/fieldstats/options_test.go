@@ -0,0 +1,70 @@
+package fieldstats
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	for _, tc := range [...]struct {
+		opts        []*agent.Option
+		fieldFormat string
+		isErr       bool
+	}{
+		{[]*agent.Option{stringOption("field", "cpu")}, "{stat}", false},
+		{[]*agent.Option{stringOption("field", "cpu,mem")}, "{field}_{stat}", false},
+		{[]*agent.Option{stringOption("field", "cpu_*")}, "{field}_{stat}", false},
+		{[]*agent.Option{stringOption("field", "cpu"), stringOption("fieldFormat", "{field}.{stat}")}, "{field}.{stat}", false},
+		{[]*agent.Option{stringOption("field", "cpu"), timeFilterOption("h==3", "UTC")}, "{stat}", false},
+		{nil, "", true},
+		{[]*agent.Option{stringOption("field", "cpu"), timeFilterOption("m==now", "")}, "", true},
+		{[]*agent.Option{stringOption("field", "cpu"), timeFilterOption("h==3", "NotExisting")}, "", true},
+	} {
+		t.Run(fmt.Sprintf("Validate %d options", len(tc.opts)), func(t *testing.T) {
+			var o Options
+			for _, opt := range tc.opts {
+				if !o.Parse(opt) {
+					t.Fatalf("expected option '%s' to be parsed", opt.Name)
+				}
+			}
+
+			err := o.Validate("test")
+			if (err != nil) != tc.isErr {
+				t.Fatalf("expected error %v, actual %v", tc.isErr, err)
+			}
+			if err == nil && o.FieldFormat != tc.fieldFormat {
+				t.Errorf("expected field format '%s', actual '%s'", tc.fieldFormat, o.FieldFormat)
+			}
+		})
+	}
+}
+
+func TestOptionsParseOther(t *testing.T) {
+	var o Options
+	if o.Parse(stringOption("method", "cusum")) {
+		t.Errorf("expected option 'method' not to be parsed")
+	}
+}
+
+func stringOption(name, value string) *agent.Option {
+	return &agent.Option{
+		Name:   name,
+		Values: []*agent.OptionValue{stringValue(value)},
+	}
+}
+
+func timeFilterOption(mask, zone string) *agent.Option {
+	return &agent.Option{
+		Name:   "timeFilter",
+		Values: []*agent.OptionValue{stringValue(mask), stringValue(zone)},
+	}
+}
+
+func stringValue(s string) *agent.OptionValue {
+	return &agent.OptionValue{
+		Type:  agent.ValueType_STRING,
+		Value: &agent.OptionValue_StringValue{StringValue: s},
+	}
+}
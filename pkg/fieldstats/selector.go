@@ -0,0 +1,132 @@
+package fieldstats
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+// Selector selects the fields to calculate statistics for, either by
+// name or by a glob like "*" (every numeric field) or "cpu_*".
+type Selector struct {
+	names    []string
+	patterns []string
+}
+
+// NewNamesSelector creates the selector of the fields by name only, even
+// if a name looks like a glob.
+func NewNamesSelector(names []string) *Selector {
+	return &Selector{names: names}
+}
+
+// NewSelector creates the selector of the fields by name or by glob.
+func NewSelector(fields []string) (*Selector, error) {
+	fs := &Selector{}
+
+	for _, field := range fields {
+		if !strings.ContainsAny(field, "*?[") {
+			fs.names = append(fs.names, field)
+			continue
+		}
+
+		if _, err := path.Match(field, ""); err != nil {
+			return nil, fmt.Errorf("invalid field pattern '%s'", field)
+		}
+		fs.patterns = append(fs.patterns, field)
+	}
+
+	if len(fs.names) == 0 && len(fs.patterns) == 0 {
+		return nil, fmt.Errorf("must supply 'field'")
+	}
+
+	return fs, nil
+}
+
+// IsSingle tells if it selects one field by name only.
+func (fs *Selector) IsSingle() bool {
+	return len(fs.names) == 1 && len(fs.patterns) == 0
+}
+
+// Names returns the fields selected by name.
+func (fs *Selector) Names() []string {
+	return fs.names
+}
+
+// Values returns the values of the selected fields of the point p,
+// leaving out the missing ones.
+func (fs *Selector) Values(p *agent.Point) map[string]float64 {
+	values, _ := fs.Read(p)
+	return values
+}
+
+// Read returns the values of the selected fields of the point p, and the
+// names of the missing ones. A field is missing if it is selected by name
+// but not a numeric field of the point, or its value is NaN or infinite.
+func (fs *Selector) Read(p *agent.Point) (map[string]float64, []string) {
+	res := make(map[string]float64, len(fs.names))
+	var missing []string
+
+	add := func(name string, val float64) {
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			missing = append(missing, name)
+			return
+		}
+		res[name] = val
+	}
+
+	for _, name := range fs.names {
+		if val, ok := p.FieldsDouble[name]; ok {
+			add(name, val)
+		} else if val, ok := p.FieldsInt[name]; ok {
+			add(name, float64(val))
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(fs.patterns) > 0 {
+		for name, val := range p.FieldsDouble {
+			if _, ok := res[name]; !ok && fs.matchPattern(name) && !fs.isNamed(name) {
+				add(name, val)
+			}
+		}
+		for name, val := range p.FieldsInt {
+			if _, ok := res[name]; !ok && fs.matchPattern(name) && !fs.isNamed(name) {
+				if _, ok := p.FieldsDouble[name]; !ok {
+					add(name, float64(val))
+				}
+			}
+		}
+	}
+
+	return res, missing
+}
+
+func (fs *Selector) isNamed(name string) bool {
+	for _, n := range fs.names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (fs *Selector) matchPattern(name string) bool {
+	for _, pattern := range fs.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OutputFieldName names the output field of the statistic of the field by
+// the format like "{field}_{stat}".
+func OutputFieldName(format string, field string, stat string) string {
+	return strings.NewReplacer("{field}", field, "{stat}", stat).Replace(format)
+}
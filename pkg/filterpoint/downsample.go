@@ -0,0 +1,137 @@
+package filterpoint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+// downsampler thins the points of a batch which pass the time mask,
+// either by keeping one point per time bucket or every Nth point.
+type downsampler struct {
+	mode     string
+	interval int64
+	field    string
+	every    int64
+
+	count   int64
+	buckets map[int64]*bucket
+}
+
+// bucket holds the point selected so far for a time bucket.
+type bucket struct {
+	point *agent.Point
+	value float64
+}
+
+func newDownsampler(mode string, interval int64, field string, every int64) (*downsampler, error) {
+	d := &downsampler{
+		mode:     mode,
+		interval: interval,
+		field:    field,
+		every:    every,
+	}
+
+	switch mode {
+	case "":
+		if every < 0 {
+			return nil, fmt.Errorf("'downsampleEvery' must be positive")
+		}
+		if every <= 1 {
+			return nil, nil
+		}
+	case "first", "last", "min", "max":
+		if interval <= 0 {
+			return nil, fmt.Errorf("'downsample' interval must be positive")
+		}
+		if every > 1 {
+			return nil, fmt.Errorf("cannot supply both 'downsample' and 'downsampleEvery'")
+		}
+		if (mode == "min" || mode == "max") && len(field) == 0 {
+			return nil, fmt.Errorf("must supply 'downsampleField' for 'downsample' mode '%s'", mode)
+		}
+	default:
+		return nil, fmt.Errorf("invalid 'downsample' mode '%s', must be 'first', 'last', 'min' or 'max'", mode)
+	}
+	d.reset()
+
+	return d, nil
+}
+
+// reset starts over for the next batch.
+func (d *downsampler) reset() {
+	d.count = 0
+	d.buckets = make(map[int64]*bucket)
+}
+
+// add takes the point p with the event time t. It returns true if the
+// point should be sent straight away, or otherwise keeps it until flush
+// if it's selected for its bucket.
+func (d *downsampler) add(t int64, p *agent.Point) bool {
+	if len(d.mode) == 0 {
+		d.count++
+		return (d.count-1)%d.every == 0
+	}
+
+	// Align the buckets with the epoch, e.g. on the minute for 1m
+	key := t / d.interval
+	if t < 0 && t%d.interval != 0 {
+		key--
+	}
+
+	val, ok := 0.0, true
+	if d.mode == "min" || d.mode == "max" {
+		if val, ok = fieldValue(d.field, p); !ok {
+			return false
+		}
+	}
+
+	b, existing := d.buckets[key]
+	if !existing {
+		d.buckets[key] = &bucket{point: p, value: val}
+		return false
+	}
+
+	switch d.mode {
+	case "last":
+		b.point = p
+	case "min":
+		if val < b.value {
+			b.point, b.value = p, val
+		}
+	case "max":
+		if val > b.value {
+			b.point, b.value = p, val
+		}
+	}
+
+	return false
+}
+
+// flush returns the points selected for the buckets in time order.
+func (d *downsampler) flush() []*agent.Point {
+	keys := make([]int64, 0, len(d.buckets))
+	for k := range d.buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	points := make([]*agent.Point, 0, len(keys))
+	for _, k := range keys {
+		points = append(points, d.buckets[k].point)
+	}
+
+	return points
+}
+
+func fieldValue(field string, p *agent.Point) (float64, bool) {
+	if val, ok := p.FieldsDouble[field]; ok {
+		return val, true
+	}
+	if val, ok := p.FieldsInt[field]; ok {
+		return float64(val), true
+	}
+
+	return 0, false
+}
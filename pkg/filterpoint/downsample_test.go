@@ -0,0 +1,109 @@
+package filterpoint
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestDownsampler(t *testing.T) {
+	// Values of the points at 0s, 5s, 10s, 25s, 30s and 59s
+	values := []float64{3, 1, 2, 7, 9, 4}
+	offsets := []int64{0, 5, 10, 25, 30, 59}
+
+	for _, tc := range [...]struct {
+		mode     string
+		interval time.Duration
+		every    int64
+		expected []float64
+	}{
+		{"first", 20 * time.Second, 0, []float64{3, 7, 4}},
+		{"last", 20 * time.Second, 0, []float64{2, 9, 4}},
+		{"min", 20 * time.Second, 0, []float64{1, 7, 4}},
+		{"max", 20 * time.Second, 0, []float64{3, 9, 4}},
+		{"max", time.Minute, 0, []float64{9}},
+		{"", 0, 2, []float64{3, 2, 9}},
+		{"", 0, 4, []float64{3, 9}},
+	} {
+		t.Run(fmt.Sprintf("Downsample by '%s' %v every %d", tc.mode, tc.interval, tc.every), func(t *testing.T) {
+			d, err := newDownsampler(tc.mode, int64(tc.interval), "value", tc.every)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			var actual []float64
+			for i, v := range values {
+				p := &agent.Point{FieldsDouble: map[string]float64{"value": v}}
+				if d.add(offsets[i]*int64(time.Second), p) {
+					actual = append(actual, v)
+				}
+			}
+			for _, p := range d.flush() {
+				actual = append(actual, p.FieldsDouble["value"])
+			}
+
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNewDownsamplerInvalid(t *testing.T) {
+	for _, tc := range [...]struct {
+		mode     string
+		interval time.Duration
+		field    string
+		every    int64
+	}{
+		{"median", time.Second, "", 0},
+		{"first", 0, "", 0},
+		{"max", time.Second, "", 0},
+		{"first", time.Second, "", 2},
+		{"", 0, "", -1},
+	} {
+		t.Run(fmt.Sprintf("New downsampler '%s'", tc.mode), func(t *testing.T) {
+			if _, err := newDownsampler(tc.mode, int64(tc.interval), tc.field, tc.every); err == nil {
+				t.Errorf("expected error, actual nil")
+			}
+		})
+	}
+}
+
+func TestDownsampleBatch(t *testing.T) {
+	ch := make(chan *agent.Response, 10)
+	fp := newFilterPoint(&agent.Agent{Responses: ch})
+	initPoint(t, fp, newInitRequest("h==3", "UTC",
+		&agent.Option{
+			Name: "downsample",
+			Values: []*agent.OptionValue{
+				stringValue("last"),
+				{Type: agent.ValueType_DURATION, Value: &agent.OptionValue_DurationValue{DurationValue: int64(time.Minute)}},
+			},
+		}))
+
+	dt, _ := time.Parse(time.RFC3339, "2019-08-26T03:15:00Z")
+	for batch := 0; batch < 2; batch++ {
+		fp.BeginBatch(&agent.BeginBatch{})
+		for _, offset := range []time.Duration{0, 10 * time.Second, time.Minute, time.Hour} {
+			fp.Point(&agent.Point{Time: dt.Add(offset).UnixNano()})
+		}
+		fp.EndBatch(&agent.EndBatch{})
+	}
+
+	// The point at 04:15 is masked out, and the last one of
+	// each minute is kept for both batches
+	expected := []time.Duration{10 * time.Second, time.Minute, 10 * time.Second, time.Minute}
+	if len(ch) != len(expected) {
+		t.Fatalf("expected %d points, actual %d", len(expected), len(ch))
+	}
+	for _, offset := range expected {
+		r := <-ch
+		if actual := r.Message.(*agent.Response_Point).Point.Time; actual != dt.Add(offset).UnixNano() {
+			t.Errorf("expected %v, actual %v", dt.Add(offset), time.Unix(0, actual).UTC())
+		}
+	}
+}
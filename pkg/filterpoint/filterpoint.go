@@ -1,22 +1,30 @@
 package filterpoint
 
 import (
+	"encoding/json"
 	"log"
 	"os"
-	"pkg/utils"
-	"regexp"
 	"strings"
 	"time"
 
 	"github.com/influxdata/kapacitor/udf/agent"
 
 	"pkg/matchtime"
+	"pkg/timesource"
+	"pkg/timezone"
 )
 
 type filterPoint struct {
-	timeZone string
+	timeZone   *timezone.Zone
+	timeSource *timesource.Source
+	timeMask   string
 
-	timeMask string
+	suppressor  *suppressor
+	downsampler *downsampler
+
+	emitStats bool
+	statsName string
+	stats     batchStats
 
 	agent *agent.Agent
 }
@@ -34,7 +42,20 @@ func (*filterPoint) Info() (*agent.InfoResponse, error) {
 		Provides: agent.EdgeType_BATCH,
 
 		Options: map[string]*agent.OptionInfo{
-			"timeFilter": {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_STRING}},
+			"timeFilter":       {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_STRING}},
+			"defaultTimeZone":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"unknownTimeZone":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"timeSource":       {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"timeSourceFormat": {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"timeSourceError":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"dedup":            {ValueTypes: []agent.ValueType{}},
+			"deadband":         {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_DOUBLE}},
+			"heartbeat":        {ValueTypes: []agent.ValueType{agent.ValueType_DURATION}},
+			"expire":           {ValueTypes: []agent.ValueType{agent.ValueType_DURATION}},
+			"downsample":       {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_DURATION}},
+			"downsampleField":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"downsampleEvery":  {ValueTypes: []agent.ValueType{agent.ValueType_INT}},
+			"stats":            {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
 		},
 	}
 
@@ -48,29 +69,136 @@ func (fp *filterPoint) Init(r *agent.InitRequest) (*agent.InitResponse, error) {
 		Error:   "",
 	}
 
+	var err error
+	var unknownTimeZone timezone.Policy
+	var timeSourceError timesource.Policy
+	timeZone, defaultTimeZone := "", ""
+	timeSource, timeSourceFormat := "", ""
+	downsample, downsampleField := "", ""
+	var downsampleInterval, downsampleEvery int64
+	dedup, deadbandField := false, ""
+	var deadband float64
+	var heartbeat, expire int64
+
 	for _, opt := range r.Options {
 		switch opt.Name {
 		case "timeFilter":
 			fp.timeMask = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
-			fp.timeZone = strings.TrimSpace(opt.Values[1].Value.(*agent.OptionValue_StringValue).StringValue)
+			timeZone = strings.TrimSpace(opt.Values[1].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "defaultTimeZone":
+			defaultTimeZone = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "unknownTimeZone":
+			unknownTimeZone, err = timezone.ParsePolicy(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "timeSource":
+			timeSource = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "timeSourceFormat":
+			timeSourceFormat = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "timeSourceError":
+			timeSourceError, err = timesource.ParsePolicy(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "dedup":
+			dedup = true
+		case "deadband":
+			deadbandField = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+			deadband = opt.Values[1].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		case "heartbeat":
+			heartbeat = opt.Values[0].Value.(*agent.OptionValue_DurationValue).DurationValue
+		case "expire":
+			expire = opt.Values[0].Value.(*agent.OptionValue_DurationValue).DurationValue
+		case "downsample":
+			downsample = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+			downsampleInterval = opt.Values[1].Value.(*agent.OptionValue_DurationValue).DurationValue
+		case "downsampleField":
+			downsampleField = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		case "downsampleEvery":
+			downsampleEvery = opt.Values[0].Value.(*agent.OptionValue_IntValue).IntValue
+		case "stats":
+			fp.emitStats = true
+			fp.statsName = strings.TrimSpace(opt.Values[0].Value.(*agent.OptionValue_StringValue).StringValue)
+		}
+
+		if err != nil {
+			init.Success = false
+			init.Error = err.Error()
+			return init, nil
 		}
 	}
 
 	if len(fp.timeMask) == 0 {
 		init.Success = false
 		init.Error = "must supply 'timeFilter'"
+		return init, nil
+	}
+
+	if fp.timeZone, err = timezone.NewZone(timeZone, defaultTimeZone, unknownTimeZone); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	if fp.timeSource, err = timesource.NewSource(timeSource, timeSourceFormat, timeSourceError); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	if fp.suppressor, err = newSuppressor(dedup, deadbandField, deadband, heartbeat, expire); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	if fp.downsampler, err = newDownsampler(downsample, downsampleInterval, downsampleField, downsampleEvery); err != nil {
+		init.Success = false
+		init.Error = err.Error()
 	}
 
 	return init, nil
 }
 
+// filterPointState is the running state saved in the snapshot.
+type filterPointState struct {
+	LastSeen map[string]*lastSeen `json:"lastSeen,omitempty"`
+}
+
 // Create a snapshot of the running state of the process.
-func (*filterPoint) Snapshot() (*agent.SnapshotResponse, error) {
-	return &agent.SnapshotResponse{}, nil
+func (fp *filterPoint) Snapshot() (*agent.SnapshotResponse, error) {
+	var state filterPointState
+	if fp.suppressor != nil {
+		state.LastSeen = fp.suppressor.groups
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &agent.SnapshotResponse{
+		Snapshot: data,
+	}, nil
 }
 
 // Restore a previous snapshot.
-func (*filterPoint) Restore(req *agent.RestoreRequest) (*agent.RestoreResponse, error) {
+func (fp *filterPoint) Restore(req *agent.RestoreRequest) (*agent.RestoreResponse, error) {
+	var state filterPointState
+	if len(req.Snapshot) > 0 {
+		if err := json.Unmarshal(req.Snapshot, &state); err != nil {
+			return &agent.RestoreResponse{
+				Success: false,
+				Error:   "failed to restore snapshot: " + err.Error(),
+			}, nil
+		}
+	}
+
+	if fp.suppressor != nil && state.LastSeen != nil {
+		// A null group is dropped, like those never seen
+		for group, ls := range state.LastSeen {
+			if ls == nil {
+				delete(state.LastSeen, group)
+			}
+		}
+		fp.suppressor.groups = state.LastSeen
+	}
+
 	return &agent.RestoreResponse{
 		Success: true,
 	}, nil
@@ -78,59 +206,88 @@ func (*filterPoint) Restore(req *agent.RestoreRequest) (*agent.RestoreResponse,
 
 // Start working with the next batch
 func (fp *filterPoint) BeginBatch(begin *agent.BeginBatch) error {
+	fp.stats = batchStats{}
+
+	// Downsample each batch (group) separately
+	if fp.downsampler != nil {
+		fp.downsampler.reset()
+	}
+
 	return nil
 }
 
 func (fp *filterPoint) Point(p *agent.Point) error {
-	// Convert nanosecond epoch format time to timezone time
-	dt := time.Unix(0, p.GetTime())
-	timeZone := parseTimeZone(fp.timeZone, p)
-	dt = converTimeToTimeZone(&dt, timeZone)
+	fp.stats.received++
 
-	// Only send back to Kapacitor the data points that match time mask
-	if len(fp.timeMask) == 0 || matchtime.MatchTimeWithMask(fp.timeMask, &dt) {
-		fp.agent.Responses <- &agent.Response{
-			Message: &agent.Response_Point{
-				Point: p,
-			},
+	// Read the event time of the point, which is the point time unless
+	// the 'timeSource' is given. A time source without a zone is in the
+	// point's time zone, so it cannot be read if that is unknown.
+	loc, zoneErr := fp.timeZone.Resolve(p)
+	t, err := fp.timeSource.Time(p, loc)
+	if err != nil {
+		fp.stats.timeSource++
+		if fp.timeSource.Policy() == timesource.PolicyFail {
+			return err
 		}
+		return nil
 	}
 
-	return nil
-}
-
-func parseTimeZone(timezone string, p *agent.Point) string {
-	if !strings.HasPrefix(timezone, "{") {
-		return timezone
+	// Convert nanosecond epoch format time to timezone time
+	if zoneErr != nil {
+		// The point's time zone is unknown, so either drop it
+		// or pass it through without matching the time mask.
+		if fp.timeZone.Policy() == timezone.PolicyPassthrough {
+			fp.sendPoint(p)
+		} else {
+			fp.stats.timeZone++
+		}
+		return nil
 	}
+	dt := time.Unix(0, t).In(loc)
 
-	re, _ := regexp.Compile(`^\{(\S+)\}$`)
-	match := re.FindStringSubmatch(timezone)
-
-	if match == nil {
-		return timezone
+	// Only send back to Kapacitor the data points that match time mask,
+	// and then only the ones not suppressed and selected by the downsampler
+	if len(fp.timeMask) > 0 && !matchtime.MatchTimeWithMask(fp.timeMask, &dt) {
+		fp.stats.maskedOut++
+		return nil
+	}
+	if fp.suppressor != nil && !fp.suppressor.keep(t, p) {
+		fp.stats.suppressed++
+		return nil
+	}
+	if fp.downsampler == nil || fp.downsampler.add(t, p) {
+		fp.sendPoint(p)
 	}
 
-	return utils.StringifyPointByKey(match[1], p)
+	return nil
 }
 
-func converTimeToTimeZone(t *time.Time, timeZone string) time.Time {
-	// You can obtain the time zone code from this link
-	// https://en.wikipedia.org/wiki/List_of_tz_database_time_zones,
-	// in which the "TZ database name" (e.g. Pacific/Auckland) is used
-	// as the time zone code.
+func (fp *filterPoint) sendPoint(p *agent.Point) {
+	fp.stats.kept++
+	fp.agent.Responses <- &agent.Response{
+		Message: &agent.Response_Point{
+			Point: p,
+		},
+	}
+}
 
-	if len(timeZone) > 0 {
-		loc, err := time.LoadLocation(timeZone)
-		if err == nil {
-			return t.In(loc)
+func (fp *filterPoint) EndBatch(end *agent.EndBatch) error {
+	// Send the points selected for each time bucket
+	if fp.downsampler != nil {
+		for _, p := range fp.downsampler.flush() {
+			fp.sendPoint(p)
 		}
 	}
 
-	return *t
-}
+	// Send the summary of the batch, even if no point is kept
+	if fp.emitStats {
+		fp.agent.Responses <- &agent.Response{
+			Message: &agent.Response_Point{
+				Point: fp.stats.toPoint(fp.statsName, end),
+			},
+		}
+	}
 
-func (fp *filterPoint) EndBatch(end *agent.EndBatch) error {
 	return nil
 }
 
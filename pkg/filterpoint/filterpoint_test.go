@@ -2,62 +2,163 @@ package filterpoint
 
 import (
 	"fmt"
-	"reflect"
 	"testing"
 	"time"
 
 	"github.com/influxdata/kapacitor/udf/agent"
 )
 
-func TestParseTimeZone(t *testing.T) {
-	pnt := getKapacitorPoint()
+func TestPoint(t *testing.T) {
+	// 2019-08-26T15:15:15 in Auckland, 03:15:15 in UTC
+	dt, _ := time.Parse(time.RFC3339, "2019-08-26T03:15:15Z")
+
+	nz := getKapacitorPoint()
+	nz.Time = dt.UnixNano()
+	unknown := getKapacitorPoint()
+	unknown.Time = dt.UnixNano()
+	unknown.FieldsString["timezone"] = "NotExisting"
 
 	for _, tc := range [...]struct {
-		timezone string
-		pntKap   *agent.Point
-		expected string
+		timeFilter      string
+		timeZone        string
+		unknownTimeZone string
+		pntKap          *agent.Point
+		expected        bool
 	}{
-		{"Pacific/Auckland", pnt, "Pacific/Auckland"},
-		{"{timezone}", pnt, "Pacific/Auckland"},
-		{"{NotExisting}", pnt, ""},
-		{"", pnt, ""},
-		{"{}", pnt, "{}"},
+		{"h==15", "Pacific/Auckland", "", nz, true},
+		{"h==15", "UTC", "", nz, false},
+		{"h==3", "UTC", "", nz, true},
+		{"h==15", "{timezone}", "", nz, true},
+		{"h==3", "{timezone}", "", unknown, true},
+		{"h==3", "{timezone}", "drop", unknown, false},
+		{"h==15", "{timezone}", "passthrough", unknown, true},
 	} {
-		t.Run(fmt.Sprintf("Test Parse Time Zone"), func(t *testing.T) {
-			actual := parseTimeZone(tc.timezone, tc.pntKap)
-			if !reflect.DeepEqual(tc.expected, actual) {
+		t.Run(fmt.Sprintf("Filter point by '%s' in '%s'", tc.timeFilter, tc.timeZone), func(t *testing.T) {
+			fp := newFilterPoint(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+			initPoint(t, fp, newInitRequest(tc.timeFilter, tc.timeZone,
+				stringOption("defaultTimeZone", "UTC"),
+				stringOption("unknownTimeZone", tc.unknownTimeZone)))
+
+			if err := fp.Point(tc.pntKap); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if actual := len(fp.agent.Responses) == 1; actual != tc.expected {
 				t.Errorf("expected %v, actual %v", tc.expected, actual)
 			}
 		})
 	}
 }
 
-func TestConverTimeToTimeZone(t *testing.T) {
-	dtNZ, _ := time.Parse(time.RFC3339, "2019-08-26T15:15:15+12:00")
-	dtUTC, _ := time.Parse(time.RFC3339, "2019-08-26T03:15:15Z")
+func TestInitInvalidTimeZone(t *testing.T) {
+	for _, tc := range [...]struct {
+		timeZone        string
+		unknownTimeZone string
+	}{
+		{"NotExisting", ""},
+		{"{}", ""},
+		{"{timezone}", "ignore"},
+	} {
+		t.Run(fmt.Sprintf("Init with time zone '%s'", tc.timeZone), func(t *testing.T) {
+			fp := newFilterPoint(&agent.Agent{})
+			init, _ := fp.Init(newInitRequest("h==15", tc.timeZone,
+				stringOption("unknownTimeZone", tc.unknownTimeZone)))
+			if init.Success {
+				t.Errorf("expected failure, actual success")
+			}
+		})
+	}
+}
+
+func TestPointTimeSource(t *testing.T) {
+	// Ingested at 2019-08-26T03:15:15Z but happened at 01:00:00Z
+	dt, _ := time.Parse(time.RFC3339, "2019-08-26T03:15:15Z")
 
-	dtDaylightSavingNZ, _ := time.Parse(time.RFC3339, "2019-10-26T15:15:15+13:00")
-	dtUTC1, _ := time.Parse(time.RFC3339, "2019-10-26T02:15:15Z")
+	late := getKapacitorPoint()
+	late.Time = dt.UnixNano()
+	late.FieldsInt["event_ts"] = 1566781200000
+	invalid := getKapacitorPoint()
+	invalid.Time = dt.UnixNano()
+	invalid.FieldsString["event_ts"] = "yesterday"
 
 	for _, tc := range [...]struct {
-		dt       time.Time
-		timezone string
-		expected time.Time
+		timeFilter      string
+		timeSourceError string
+		pntKap          *agent.Point
+		expected        bool
+		isErr           bool
 	}{
-		{dtUTC, "", dtUTC},
-		{dtNZ, "NotExisting", dtNZ},
-		{dtUTC, "Pacific/Auckland", dtNZ},
-		{dtUTC1, "Pacific/Auckland", dtDaylightSavingNZ},
+		{"h==1", "", late, true, false},
+		{"h==3", "", late, false, false},
+		{"h==3", "", invalid, true, false},
+		{"h==3", "drop", invalid, false, false},
+		{"h==3", "fail", invalid, false, true},
 	} {
-		t.Run(fmt.Sprintf("Test Parse Time Zone"), func(t *testing.T) {
-			actual := converTimeToTimeZone(&tc.dt, tc.timezone)
-			if actual.Sub(tc.expected) != 0 {
+		t.Run(fmt.Sprintf("Filter point by '%s' on event time", tc.timeFilter), func(t *testing.T) {
+			fp := newFilterPoint(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+			initPoint(t, fp, newInitRequest(tc.timeFilter, "UTC",
+				stringOption("timeSource", "event_ts"),
+				stringOption("timeSourceError", tc.timeSourceError)))
+
+			if err := fp.Point(tc.pntKap); (err != nil) != tc.isErr {
+				t.Fatalf("expected error %v, actual %v", tc.isErr, err)
+			}
+			if actual := len(fp.agent.Responses) == 1; actual != tc.expected {
 				t.Errorf("expected %v, actual %v", tc.expected, actual)
 			}
 		})
 	}
 }
 
+func initPoint(t *testing.T, fp *filterPoint, r *agent.InitRequest) {
+	t.Helper()
+
+	init, _ := fp.Init(r)
+	if !init.Success {
+		t.Fatalf("unexpected init error %v", init.Error)
+	}
+}
+
+func newInitRequest(timeFilter, timeZone string, opts ...*agent.Option) *agent.InitRequest {
+	r := &agent.InitRequest{
+		Options: []*agent.Option{
+			{
+				Name: "timeFilter",
+				Values: []*agent.OptionValue{
+					stringValue(timeFilter),
+					stringValue(timeZone),
+				},
+			},
+		},
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			r.Options = append(r.Options, opt)
+		}
+	}
+
+	return r
+}
+
+// stringOption returns nil for an empty value, so the option is omitted.
+func stringOption(name, value string) *agent.Option {
+	if len(value) == 0 {
+		return nil
+	}
+
+	return &agent.Option{
+		Name:   name,
+		Values: []*agent.OptionValue{stringValue(value)},
+	}
+}
+
+func stringValue(s string) *agent.OptionValue {
+	return &agent.OptionValue{
+		Type:  agent.ValueType_STRING,
+		Value: &agent.OptionValue_StringValue{StringValue: s},
+	}
+}
+
 func getKapacitorPoint() *agent.Point {
 	return &agent.Point{
 		FieldsInt: map[string]int64{
@@ -0,0 +1,55 @@
+package filterpoint
+
+import (
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+// batchStats counts what happened to the points of a batch, so that an
+// empty batch tells if the data was missing or filtered out.
+type batchStats struct {
+	received int64
+	kept     int64
+
+	// Points dropped for an unreadable time source, an unknown time
+	// zone, not matching the time mask or being a duplicate
+	timeSource int64
+	timeZone   int64
+	maskedOut  int64
+	suppressed int64
+}
+
+// toPoint returns the summary point of the batch. The rest of the dropped
+// points, not counted by any other reason, were dropped by downsampling.
+// Without a name, the measurement is the one of the batch suffixed with
+// "_filter_stats", so that the point never overwrites a point of the data.
+func (s *batchStats) toPoint(name string, end *agent.EndBatch) *agent.Point {
+	if len(name) == 0 {
+		name = "filter_stats"
+		if len(end.GetName()) > 0 {
+			name = end.GetName() + "_" + name
+		}
+	}
+
+	dropped := s.received - s.kept
+	tags := make(map[string]string, len(end.GetTags()))
+	for k, v := range end.GetTags() {
+		tags[k] = v
+	}
+
+	return &agent.Point{
+		Name:  name,
+		Time:  end.GetTmax(),
+		Group: end.GetGroup(),
+		Tags:  tags,
+		FieldsInt: map[string]int64{
+			"received":             s.received,
+			"kept":                 s.kept,
+			"dropped":              dropped,
+			"dropped_time_source":  s.timeSource,
+			"dropped_time_zone":    s.timeZone,
+			"dropped_time_mask":    s.maskedOut,
+			"dropped_unchanged":    s.suppressed,
+			"dropped_downsampling": dropped - s.timeSource - s.timeZone - s.maskedOut - s.suppressed,
+		},
+	}
+}
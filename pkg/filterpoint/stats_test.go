@@ -0,0 +1,87 @@
+package filterpoint
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestBatchStats(t *testing.T) {
+	ch := make(chan *agent.Response, 10)
+	fp := newFilterPoint(&agent.Agent{Responses: ch})
+	initPoint(t, fp, newInitRequest("h==3", "{timezone}",
+		stringOption("unknownTimeZone", "drop"),
+		stringOption("timeSource", "event_ts"),
+		stringOption("timeSourceError", "drop"),
+		stringOption("stats", "filter_stats"),
+		&agent.Option{Name: "dedup"}))
+
+	dt, _ := time.Parse(time.RFC3339, "2019-08-26T03:15:00Z")
+	newPoint := func(offset time.Duration, timezone string) *agent.Point {
+		return &agent.Point{
+			Tags:      map[string]string{"timezone": timezone},
+			FieldsInt: map[string]int64{"event_ts": dt.Add(offset).UnixNano()},
+		}
+	}
+
+	fp.BeginBatch(&agent.BeginBatch{})
+	fp.Point(newPoint(0, "UTC"))
+	fp.Point(newPoint(0, "UTC"))                      // duplicate
+	fp.Point(newPoint(time.Minute, "UTC"))            // kept
+	fp.Point(newPoint(time.Hour, "UTC"))              // masked out
+	fp.Point(newPoint(0, "NotExisting"))              // unknown time zone
+	fp.Point(&agent.Point{Tags: map[string]string{}}) // missing time source
+	fp.EndBatch(&agent.EndBatch{
+		Name:  "cpu",
+		Group: "host=a",
+		Tmax:  dt.UnixNano(),
+		Tags:  map[string]string{"host": "a"},
+	})
+
+	if len(ch) != 3 {
+		t.Fatalf("expected 3 responses, actual %d", len(ch))
+	}
+	<-ch
+	<-ch
+	actual := (<-ch).Message.(*agent.Response_Point).Point
+
+	expected := &agent.Point{
+		Name:  "filter_stats",
+		Time:  dt.UnixNano(),
+		Group: "host=a",
+		Tags:  map[string]string{"host": "a"},
+		FieldsInt: map[string]int64{
+			"received":             6,
+			"kept":                 2,
+			"dropped":              4,
+			"dropped_time_source":  1,
+			"dropped_time_zone":    1,
+			"dropped_time_mask":    1,
+			"dropped_unchanged":    1,
+			"dropped_downsampling": 0,
+		},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+}
+
+func TestBatchStatsDefaultName(t *testing.T) {
+	for _, tc := range [...]struct {
+		batchName string
+		expected  string
+	}{
+		{"cpu", "cpu_filter_stats"},
+		{"", "filter_stats"},
+	} {
+		t.Run(fmt.Sprintf("Name of the stats of batch '%s'", tc.batchName), func(t *testing.T) {
+			s := &batchStats{}
+			if actual := s.toPoint("", &agent.EndBatch{Name: tc.batchName}).Name; actual != tc.expected {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
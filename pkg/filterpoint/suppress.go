@@ -0,0 +1,148 @@
+package filterpoint
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+// suppressor drops the points which repeat the previous point of their
+// group, or whose field has not changed by more than the deadband since
+// the last point sent. It still sends a point every heartbeat. A group
+// idle for longer than the expiry is forgotten, so that the state of a
+// group-by of high cardinality doesn't grow without bound.
+type suppressor struct {
+	dedup     bool
+	field     string
+	deadband  float64
+	heartbeat int64
+	expire    int64
+
+	groups    map[string]*lastSeen
+	nextSweep int64
+}
+
+// lastSeen is the state of a group kept across batches.
+type lastSeen struct {
+	// Time and fields of the previous point
+	Time   int64  `json:"time"`
+	Fields string `json:"fields"`
+
+	// Time and deadband field value of the last point sent
+	Sent     int64   `json:"sent"`
+	Value    float64 `json:"value"`
+	HasValue bool    `json:"hasValue"`
+}
+
+// newSuppressor returns nil if neither 'dedup' nor 'deadband' is supplied.
+// The expiry is the heartbeat by default, as a group idle for longer is
+// sent on its next point anyway.
+func newSuppressor(dedup bool, field string, deadband float64, heartbeat int64, expire int64) (*suppressor, error) {
+	if !dedup && len(field) == 0 {
+		if heartbeat != 0 || expire != 0 {
+			return nil, fmt.Errorf("'heartbeat' and 'expire' require 'dedup' or 'deadband'")
+		}
+		return nil, nil
+	}
+
+	if deadband < 0 || math.IsNaN(deadband) {
+		return nil, fmt.Errorf("'deadband' must not be negative")
+	}
+	if heartbeat < 0 || expire < 0 {
+		return nil, fmt.Errorf("'heartbeat' and 'expire' must not be negative")
+	}
+
+	if expire == 0 {
+		expire = heartbeat
+	}
+	if heartbeat > 0 && expire < heartbeat {
+		return nil, fmt.Errorf("'expire' must not be shorter than 'heartbeat'")
+	}
+
+	return &suppressor{
+		dedup:     dedup,
+		field:     field,
+		deadband:  deadband,
+		heartbeat: heartbeat,
+		expire:    expire,
+		groups:    make(map[string]*lastSeen),
+	}, nil
+}
+
+// keep tells if the point p with the event time t should be sent.
+func (s *suppressor) keep(t int64, p *agent.Point) bool {
+	s.expireIdle(t)
+
+	fields := fingerprintFields(p)
+	val, hasVal := fieldValue(s.field, p)
+
+	ls, ok := s.groups[p.GetGroup()]
+	if !ok {
+		s.groups[p.GetGroup()] = &lastSeen{
+			Time:     t,
+			Fields:   fields,
+			Sent:     t,
+			Value:    val,
+			HasValue: hasVal,
+		}
+		return true
+	}
+
+	suppress := s.dedup && t == ls.Time && fields == ls.Fields
+	if len(s.field) > 0 && hasVal && ls.HasValue && math.Abs(val-ls.Value) <= s.deadband {
+		suppress = true
+	}
+	ls.Time, ls.Fields = t, fields
+
+	if suppress && (s.heartbeat == 0 || t-ls.Sent < s.heartbeat) {
+		return false
+	}
+
+	ls.Sent = t
+	if hasVal {
+		ls.Value, ls.HasValue = val, true
+	}
+
+	return true
+}
+
+// expireIdle forgets the groups last seen longer than the expiry before
+// the time t. The groups are swept at most once per expiry.
+func (s *suppressor) expireIdle(t int64) {
+	if s.expire == 0 || t < s.nextSweep {
+		return
+	}
+
+	for group, ls := range s.groups {
+		if t-ls.Time > s.expire {
+			delete(s.groups, group)
+		}
+	}
+	s.nextSweep = t + s.expire
+}
+
+func fingerprintFields(p *agent.Point) string {
+	// Build the string like "d:cpu=0.5,i:count=3,s:host=a," from all
+	// fields of the point, with the keys sorted to make it stable.
+
+	items := make([]string, 0, len(p.FieldsDouble)+len(p.FieldsInt)+len(p.FieldsString)+len(p.FieldsBool))
+	for k, v := range p.FieldsDouble {
+		items = append(items, "d:"+k+"="+strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	for k, v := range p.FieldsInt {
+		items = append(items, "i:"+k+"="+strconv.FormatInt(v, 10))
+	}
+	for k, v := range p.FieldsString {
+		items = append(items, "s:"+k+"="+strconv.Quote(v))
+	}
+	for k, v := range p.FieldsBool {
+		items = append(items, "b:"+k+"="+strconv.FormatBool(v))
+	}
+	sort.Strings(items)
+
+	return strings.Join(items, ",")
+}
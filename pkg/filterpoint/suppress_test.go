@@ -0,0 +1,156 @@
+package filterpoint
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestSuppressorKeep(t *testing.T) {
+	type pnt struct {
+		sec   int64
+		group string
+		value float64
+	}
+	points := []pnt{
+		{0, "a", 1.0},
+		{0, "a", 1.0},  // duplicate
+		{0, "b", 1.0},  // other group
+		{10, "a", 1.0}, // unchanged
+		{20, "a", 1.2}, // within deadband
+		{30, "a", 1.6}, // changed
+		{90, "a", 1.6}, // unchanged but heartbeat
+	}
+
+	for _, tc := range [...]struct {
+		dedup     bool
+		field     string
+		deadband  float64
+		heartbeat time.Duration
+		expected  []bool
+	}{
+		{true, "", 0, 0, []bool{true, false, true, true, true, true, true}},
+		{false, "value", 0, 0, []bool{true, false, true, false, true, true, false}},
+		{false, "value", 0.5, 0, []bool{true, false, true, false, false, true, false}},
+		{true, "value", 0.5, time.Minute, []bool{true, false, true, false, false, true, true}},
+	} {
+		t.Run(fmt.Sprintf("Suppress with dedup %v deadband %v", tc.dedup, tc.deadband), func(t *testing.T) {
+			s, err := newSuppressor(tc.dedup, tc.field, tc.deadband, int64(tc.heartbeat), 0)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			var actual []bool
+			for _, p := range points {
+				sec := p.sec * int64(time.Second)
+				actual = append(actual, s.keep(sec, &agent.Point{
+					Time:         sec,
+					Group:        p.group,
+					FieldsDouble: map[string]float64{"value": p.value},
+				}))
+			}
+
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSuppressorExpire(t *testing.T) {
+	s, err := newSuppressor(false, "value", 0.5, 0, int64(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for _, p := range []struct {
+		sec   int64
+		group string
+	}{
+		{0, "b"},
+		{30, "a"},
+		{90, "a"}, // group "b" is idle for longer than a minute
+	} {
+		sec := p.sec * int64(time.Second)
+		s.keep(sec, &agent.Point{Time: sec, Group: p.group, FieldsDouble: map[string]float64{"value": 1}})
+	}
+
+	if _, ok := s.groups["b"]; ok {
+		t.Errorf("expected the idle group to expire")
+	}
+	if _, ok := s.groups["a"]; !ok {
+		t.Errorf("expected the active group to be kept")
+	}
+
+	// The expired group starts over, so its next point is sent
+	if !s.keep(int64(100*time.Second), &agent.Point{Group: "b", FieldsDouble: map[string]float64{"value": 1}}) {
+		t.Errorf("expected the point of the expired group to be sent")
+	}
+}
+
+func TestNewSuppressorInvalid(t *testing.T) {
+	for _, tc := range [...]struct {
+		dedup     bool
+		heartbeat time.Duration
+		expire    time.Duration
+	}{
+		{false, 0, time.Minute},
+		{true, 0, -time.Minute},
+		{true, time.Hour, time.Minute},
+	} {
+		t.Run(fmt.Sprintf("Heartbeat %v expire %v", tc.heartbeat, tc.expire), func(t *testing.T) {
+			if _, err := newSuppressor(tc.dedup, "", 0, int64(tc.heartbeat), int64(tc.expire)); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestSuppressorSnapshotRestore(t *testing.T) {
+	req := newInitRequest("Y>=1970", "UTC", &agent.Option{Name: "dedup"})
+
+	dt, _ := time.Parse(time.RFC3339, "2019-08-26T03:15:15Z")
+	p := &agent.Point{Time: dt.UnixNano(), Group: "a", FieldsInt: map[string]int64{"count": 3}}
+
+	ch := make(chan *agent.Response, 1)
+	fp := newFilterPoint(&agent.Agent{Responses: ch})
+	initPoint(t, fp, req)
+	fp.Point(p)
+	if len(ch) != 1 {
+		t.Fatalf("expected the first point to be sent")
+	}
+	<-ch
+
+	snapshot, err := fp.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// A new process restored from the snapshot drops the duplicate
+	restoredCh := make(chan *agent.Response, 1)
+	restored := newFilterPoint(&agent.Agent{Responses: restoredCh})
+	initPoint(t, restored, req)
+	if r, _ := restored.Restore(&agent.RestoreRequest{Snapshot: snapshot.Snapshot}); !r.Success {
+		t.Fatalf("unexpected restore error %v", r.Error)
+	}
+	restored.Point(p)
+	if len(restoredCh) != 0 {
+		t.Errorf("expected duplicate to be dropped after restore")
+	}
+
+	// A null group starts over
+	if r, _ := restored.Restore(&agent.RestoreRequest{Snapshot: []byte(`{"lastSeen":{"a":null}}`)}); !r.Success {
+		t.Fatalf("unexpected restore error %v", r.Error)
+	}
+	restored.Point(p)
+	if len(restoredCh) != 1 {
+		t.Errorf("expected the point of a null group to be sent after restore")
+	}
+
+	if r, _ := restored.Restore(&agent.RestoreRequest{Snapshot: []byte("{")}); r.Success {
+		t.Errorf("expected restore of invalid snapshot to fail")
+	}
+}
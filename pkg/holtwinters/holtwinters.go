@@ -0,0 +1,339 @@
+package holtwinters
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+
+	"pkg/fieldstats"
+	"pkg/matchtime"
+)
+
+// holtWinters forecasts the fields by additive Holt-Winters, fitted per
+// group over the points of the batches one after another. At the end of
+// each batch it sends the forecast of the latest point, the band of the
+// threshold times the stddev of the residuals around it, and the
+// residual of the point. The variance of the residuals is smoothed too,
+// so that the band follows a change of regime.
+type holtWinters struct {
+	fieldstats.Options
+
+	alpha     float64 // smoothing of the level
+	beta      float64 // of the trend
+	gamma     float64 // of the season
+	delta     float64 // of the variance of the residuals
+	season    int64   // number of points in a season
+	threshold float64 // width of the band in stddevs
+
+	groups map[string]*hwGroup
+
+	// The results of the latest point by field in the batch
+	latest     map[string]hwResult
+	latestTime int64
+
+	agent *agent.Agent
+}
+
+// hwGroup is the fitted models of the fields of a group.
+type hwGroup struct {
+	Models map[string]*hwModel `json:"models"`
+}
+
+// hwModel is the fitted state of a field. Until a season of points is
+// seen, they are kept to initialize the level and the seasonal terms.
+type hwModel struct {
+	Level    float64   `json:"level"`
+	Trend    float64   `json:"trend"`
+	Seasonal []float64 `json:"seasonal"`
+	Index    int       `json:"index"`
+	Initial  []float64 `json:"initial,omitempty"`
+
+	// The smoothed variance of the residuals, for the stddev of the band
+	Variance float64 `json:"variance"`
+	N        int64   `json:"n"`
+}
+
+type hwResult struct {
+	forecast float64
+	residual float64
+	stddev   float64
+	banded   bool // whether there were residuals before for the band
+}
+
+func newHoltWinters(agent *agent.Agent) *holtWinters {
+	return &holtWinters{
+		agent:  agent,
+		groups: make(map[string]*hwGroup),
+	}
+}
+
+// Return the InfoResponse. Describing the properties of this UDF agent.
+func (*holtWinters) Info() (*agent.InfoResponse, error) {
+	info := &agent.InfoResponse{
+		Wants:    agent.EdgeType_BATCH,
+		Provides: agent.EdgeType_BATCH,
+
+		Options: map[string]*agent.OptionInfo{
+			"timeFilter":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_STRING}},
+			"field":       {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"fieldFormat": {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"alpha":       {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+			"beta":        {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+			"gamma":       {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+			"delta":       {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+			"season":      {ValueTypes: []agent.ValueType{agent.ValueType_INT}},
+			"threshold":   {ValueTypes: []agent.ValueType{agent.ValueType_DOUBLE}},
+		},
+	}
+
+	return info, nil
+}
+
+// Initialze the handler based of the provided options.
+func (hw *holtWinters) Init(r *agent.InitRequest) (*agent.InitResponse, error) {
+	init := &agent.InitResponse{
+		Success: true,
+		Error:   "",
+	}
+
+	hw.alpha, hw.beta, hw.gamma, hw.delta = 0.5, 0.1, 0.1, 0.1
+	hw.threshold = 3.0
+
+	for _, opt := range r.Options {
+		if hw.Parse(opt) {
+			continue
+		}
+
+		switch opt.Name {
+		case "alpha":
+			hw.alpha = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		case "beta":
+			hw.beta = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		case "gamma":
+			hw.gamma = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		case "delta":
+			hw.delta = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		case "season":
+			hw.season = opt.Values[0].Value.(*agent.OptionValue_IntValue).IntValue
+		case "threshold":
+			hw.threshold = opt.Values[0].Value.(*agent.OptionValue_DoubleValue).DoubleValue
+		}
+	}
+
+	if err := hw.Validate("holtwinters"); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	for name, v := range map[string]float64{"alpha": hw.alpha, "beta": hw.beta, "gamma": hw.gamma, "delta": hw.delta} {
+		if v < 0 || v > 1 {
+			init.Success = false
+			init.Error = fmt.Sprintf("'%s' must be between 0 and 1", name)
+			return init, nil
+		}
+	}
+
+	if hw.season <= 0 || hw.threshold <= 0 {
+		init.Success = false
+		init.Error = "'season' and 'threshold' must be positive"
+	}
+
+	return init, nil
+}
+
+// Create a snapshot of the running state of the process, which is the
+// fitted models.
+func (hw *holtWinters) Snapshot() (*agent.SnapshotResponse, error) {
+	data, err := json.Marshal(hw.groups)
+	if err != nil {
+		return nil, err
+	}
+
+	return &agent.SnapshotResponse{
+		Snapshot: data,
+	}, nil
+}
+
+// Restore a previous snapshot.
+func (hw *holtWinters) Restore(req *agent.RestoreRequest) (*agent.RestoreResponse, error) {
+	groups := make(map[string]*hwGroup)
+	if len(req.Snapshot) > 0 {
+		if err := json.Unmarshal(req.Snapshot, &groups); err != nil {
+			return &agent.RestoreResponse{
+				Success: false,
+				Error:   "failed to restore snapshot: " + err.Error(),
+			}, nil
+		}
+	}
+
+	// A null group or model is dropped, and a model of another season
+	// length can't be carried on
+	for key, g := range groups {
+		if g == nil {
+			delete(groups, key)
+			continue
+		}
+		if g.Models == nil {
+			g.Models = make(map[string]*hwModel)
+		}
+		for field, m := range g.Models {
+			if m == nil || (len(m.Seasonal) > 0 && (int64(len(m.Seasonal)) != hw.season || m.Index < 0 || m.Index >= len(m.Seasonal))) {
+				delete(g.Models, field)
+			}
+		}
+	}
+	hw.groups = groups
+
+	return &agent.RestoreResponse{
+		Success: true,
+	}, nil
+}
+
+// Start working with the next batch
+func (hw *holtWinters) BeginBatch(begin *agent.BeginBatch) error {
+	hw.latest = make(map[string]hwResult)
+	hw.latestTime = math.MinInt64
+
+	return nil
+}
+
+func (hw *holtWinters) Point(p *agent.Point) error {
+	// Only process data points that match time mask
+	dt, _ := hw.TimeZone.In(p.GetTime(), p)
+	if len(hw.TimeMask) > 0 && !matchtime.MatchTimeWithMask(hw.TimeMask, &dt) {
+		return nil
+	}
+
+	g, ok := hw.groups[p.GetGroup()]
+	if !ok {
+		g = &hwGroup{Models: make(map[string]*hwModel)}
+		hw.groups[p.GetGroup()] = g
+	}
+
+	for field, val := range hw.Fields.Values(p) {
+		m, ok := g.Models[field]
+		if !ok {
+			m = &hwModel{}
+			g.Models[field] = m
+		}
+
+		if r, ok := m.update(val, hw); ok {
+			hw.latest[field] = r
+		}
+	}
+
+	if p.GetTime() > hw.latestTime {
+		hw.latestTime = p.GetTime()
+	}
+
+	return nil
+}
+
+// update forecasts the value from the model, and fits the model to it.
+// There is no forecast till the model is initialized by a season.
+func (m *hwModel) update(x float64, hw *holtWinters) (hwResult, bool) {
+	if len(m.Seasonal) == 0 {
+		m.Initial = append(m.Initial, x)
+		if int64(len(m.Initial)) < hw.season {
+			return hwResult{}, false
+		}
+
+		// The level is the mean of the first season, and the seasonal
+		// terms are the deviations from it
+		var acc fieldstats.Accumulator
+		for _, v := range m.Initial {
+			acc.Add(v)
+		}
+		m.Level = acc.Mean()
+		m.Seasonal = make([]float64, len(m.Initial))
+		for i, v := range m.Initial {
+			m.Seasonal[i] = v - m.Level
+		}
+		m.Initial = nil
+
+		return hwResult{}, false
+	}
+
+	s := m.Seasonal[m.Index]
+	forecast := m.Level + m.Trend + s
+	residual := x - forecast
+
+	level := hw.alpha*(x-s) + (1-hw.alpha)*(m.Level+m.Trend)
+	m.Trend = hw.beta*(level-m.Level) + (1-hw.beta)*m.Trend
+	m.Level = level
+	m.Seasonal[m.Index] = hw.gamma*(x-level) + (1-hw.gamma)*s
+	m.Index = (m.Index + 1) % len(m.Seasonal)
+
+	// The band is of the residuals before the point, so that the point
+	// doesn't widen the band it's judged by
+	r := hwResult{
+		forecast: forecast,
+		residual: residual,
+		stddev:   math.Sqrt(m.Variance),
+		banded:   m.N > 0,
+	}
+	if m.N == 0 {
+		m.Variance = residual * residual
+	} else {
+		m.Variance = hw.delta*residual*residual + (1-hw.delta)*m.Variance
+	}
+	m.N++
+
+	return r, true
+}
+
+// Send the forecast of the latest point of the batch, if any
+func (hw *holtWinters) EndBatch(end *agent.EndBatch) error {
+	if len(hw.latest) == 0 {
+		return nil
+	}
+
+	p := &agent.Point{
+		Time:         hw.latestTime,
+		Name:         end.GetName(),
+		Group:        end.GetGroup(),
+		Tags:         end.GetTags(),
+		FieldsDouble: make(map[string]float64),
+	}
+
+	for field, r := range hw.latest {
+		p.FieldsDouble[fieldstats.OutputFieldName(hw.FieldFormat, field, "forecast")] = r.forecast
+		if r.banded {
+			p.FieldsDouble[fieldstats.OutputFieldName(hw.FieldFormat, field, "upper")] = r.forecast + hw.threshold*r.stddev
+			p.FieldsDouble[fieldstats.OutputFieldName(hw.FieldFormat, field, "lower")] = r.forecast - hw.threshold*r.stddev
+		}
+		p.FieldsDouble[fieldstats.OutputFieldName(hw.FieldFormat, field, "residual")] = r.residual
+	}
+
+	hw.agent.Responses <- &agent.Response{
+		Message: &agent.Response_Point{
+			Point: p,
+		},
+	}
+
+	return nil
+}
+
+// Stop the handler gracefully.
+func (hw *holtWinters) Stop() {
+	close(hw.agent.Responses)
+}
+
+// Start is the entry point to start the Holt-Winters UDF
+func Start() {
+	a := agent.New(os.Stdin, os.Stdout)
+	h := newHoltWinters(a)
+	a.Handler = h
+
+	log.Println("Starting agent 'holtwinters'")
+	a.Start()
+	err := a.Wait()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,151 @@
+package holtwinters
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestForecast(t *testing.T) {
+	hw, ch := initHoltWinters(t)
+
+	for i, tc := range [...]struct {
+		values   []float64
+		expected map[string]float64
+		restore  bool
+	}{
+		// The first season only initializes the model
+		{[]float64{10, 20}, nil, false},
+		// The band is of the residuals before the point: the residual of 10
+		// is 0, that of 26 is 6
+		{[]float64{10, 26}, map[string]float64{
+			"forecast": 20, "residual": 6, "upper": 20, "lower": 20,
+		}, false},
+		{[]float64{12}, map[string]float64{
+			"forecast": 13.3, "residual": -1.3, "upper": 13.3 + 3*math.Sqrt(3.6), "lower": 13.3 - 3*math.Sqrt(3.6),
+		}, true},
+	} {
+		t.Run(fmt.Sprintf("Batch %d of %v", i, tc.values), func(t *testing.T) {
+			if tc.restore {
+				snapshot, err := hw.Snapshot()
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				hw, ch = initHoltWinters(t)
+				if r, _ := hw.Restore(&agent.RestoreRequest{Snapshot: snapshot.Snapshot}); !r.Success {
+					t.Fatalf("unexpected restore failure %v", r.Error)
+				}
+			}
+
+			hw.BeginBatch(&agent.BeginBatch{Name: "requests", Group: "host=a"})
+			for j, v := range tc.values {
+				hw.Point(&agent.Point{Time: int64(i*10 + j), Group: "host=a", FieldsDouble: map[string]float64{"rate": v}})
+			}
+			hw.EndBatch(&agent.EndBatch{Name: "requests", Group: "host=a"})
+
+			if tc.expected == nil {
+				if len(ch) != 0 {
+					t.Errorf("expected no forecast before a season, actual %v", <-ch)
+				}
+				return
+			}
+
+			p := (<-ch).Message.(*agent.Response_Point).Point
+			if p.Time != int64(i*10+len(tc.values)-1) || p.Group != "host=a" {
+				t.Errorf("expected the time of the latest point, actual %v", p.Time)
+			}
+			for name, want := range tc.expected {
+				if math.Abs(p.FieldsDouble[name]-want) > 1e-9 {
+					t.Errorf("expected %s %v, actual %v", name, want, p.FieldsDouble[name])
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidInit(t *testing.T) {
+	for _, opts := range [][]*agent.Option{
+		{stringOption("field", "rate")},
+		{stringOption("field", "rate"), intOption("season", 2), {
+			Name:   "alpha",
+			Values: []*agent.OptionValue{{Type: agent.ValueType_DOUBLE, Value: &agent.OptionValue_DoubleValue{DoubleValue: 1.5}}},
+		}},
+	} {
+		t.Run(fmt.Sprintf("Init with %d options", len(opts)), func(t *testing.T) {
+			hw := newHoltWinters(&agent.Agent{})
+			if init, _ := hw.Init(newInitRequest(opts...)); init.Success {
+				t.Errorf("expected failure, actual success")
+			}
+		})
+	}
+}
+
+func TestRestoreNull(t *testing.T) {
+	for _, snapshot := range []string{
+		`{"host=a":null}`,
+		`{"host=a":{"models":null}}`,
+		`{"host=a":{"models":{"rate":null}}}`,
+		`{"host=a":{"models":{"rate":{"seasonal":[1,2],"index":5}}}}`,
+	} {
+		t.Run(fmt.Sprintf("Restore %s", snapshot), func(t *testing.T) {
+			hw, ch := initHoltWinters(t)
+			if r, _ := hw.Restore(&agent.RestoreRequest{Snapshot: []byte(snapshot)}); !r.Success {
+				t.Fatalf("unexpected restore failure %v", r.Error)
+			}
+
+			// The group starts over with a new model
+			for _, v := range []float64{10, 20, 10} {
+				hw.BeginBatch(&agent.BeginBatch{Name: "requests", Group: "host=a"})
+				hw.Point(&agent.Point{Group: "host=a", FieldsDouble: map[string]float64{"rate": v}})
+				hw.EndBatch(&agent.EndBatch{Name: "requests", Group: "host=a"})
+			}
+			if len(ch) != 1 {
+				t.Errorf("expected a forecast after a season, actual %d responses", len(ch))
+			}
+		})
+	}
+}
+
+func initHoltWinters(t *testing.T) (*holtWinters, chan *agent.Response) {
+	t.Helper()
+
+	ch := make(chan *agent.Response, 1)
+	hw := newHoltWinters(&agent.Agent{Responses: ch})
+	init, _ := hw.Init(newInitRequest(stringOption("field", "rate"), intOption("season", 2)))
+	if !init.Success {
+		t.Fatalf("unexpected init failure %v", init.Error)
+	}
+
+	return hw, ch
+}
+
+func newInitRequest(opts ...*agent.Option) *agent.InitRequest {
+	r := &agent.InitRequest{}
+	for _, opt := range opts {
+		if opt != nil {
+			r.Options = append(r.Options, opt)
+		}
+	}
+
+	return r
+}
+
+func stringOption(name, value string) *agent.Option {
+	return &agent.Option{
+		Name: name,
+		Values: []*agent.OptionValue{
+			{Type: agent.ValueType_STRING, Value: &agent.OptionValue_StringValue{StringValue: value}},
+		},
+	}
+}
+
+func intOption(name string, value int64) *agent.Option {
+	return &agent.Option{
+		Name: name,
+		Values: []*agent.OptionValue{
+			{Type: agent.ValueType_INT, Value: &agent.OptionValue_IntValue{IntValue: value}},
+		},
+	}
+}
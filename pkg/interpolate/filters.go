@@ -0,0 +1,211 @@
+package interpolate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"pkg/timezone"
+)
+
+// Filter transforms the value of a placeholder, like "upper" in
+// "{host | upper}". The arguments are the words after the name of the
+// filter, unquoted, which are bound once when the template is compiled.
+type Filter struct {
+	// MinArgs and MaxArgs bound the number of arguments, a negative
+	// MaxArgs meaning no bound.
+	MinArgs int
+	MaxArgs int
+
+	// Bind validates and parses the arguments, and returns the function
+	// applied to the values.
+	Bind func(args []string) (Func, error)
+}
+
+// Func is a filter bound to its arguments. The value is a string, int64,
+// float64, bool or time.Time.
+type Func func(val interface{}) (interface{}, error)
+
+// NoArgs is the filter of the function without arguments.
+func NoArgs(fn Func) Filter {
+	return Filter{Bind: func(args []string) (Func, error) {
+		return fn, nil
+	}}
+}
+
+var (
+	filtersMu sync.RWMutex
+	filters   = map[string]Filter{}
+)
+
+// RegisterFilter makes the filter available to the templates under the
+// name, replacing any filter of that name. It is meant to be called from
+// an init function, before the agent starts.
+func RegisterFilter(name string, f Filter) {
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+
+	filters[name] = f
+}
+
+func lookupFilter(name string) (Filter, bool) {
+	filtersMu.RLock()
+	defer filtersMu.RUnlock()
+
+	f, ok := filters[name]
+	return f, ok
+}
+
+func init() {
+	RegisterFilter("upper", NoArgs(func(val interface{}) (interface{}, error) {
+		return strings.ToUpper(stringify(val)), nil
+	}))
+	RegisterFilter("lower", NoArgs(func(val interface{}) (interface{}, error) {
+		return strings.ToLower(stringify(val)), nil
+	}))
+	RegisterFilter("trim", NoArgs(func(val interface{}) (interface{}, error) {
+		return strings.TrimSpace(stringify(val)), nil
+	}))
+	RegisterFilter("truncate", Filter{MinArgs: 1, MaxArgs: 1, Bind: bindTruncate})
+	RegisterFilter("round", Filter{MaxArgs: 1, Bind: bindRound})
+	RegisterFilter("humanizeDuration", NoArgs(humanizeDuration))
+	RegisterFilter("format", Filter{MinArgs: 1, MaxArgs: 2, Bind: bindFormatTime})
+	RegisterFilter("map", Filter{MinArgs: 1, MaxArgs: 1, Bind: bindMap})
+}
+
+func parseNonNegativeInt(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a non-negative integer, found '%s'", arg)
+	}
+
+	return n, nil
+}
+
+// bindTruncate cuts the value to at most n characters.
+func bindTruncate(args []string) (Func, error) {
+	n, err := parseNonNegativeInt(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return func(val interface{}) (interface{}, error) {
+		str := stringify(val)
+		if utf8.RuneCountInString(str) <= n {
+			return str, nil
+		}
+
+		return string([]rune(str)[:n]), nil
+	}, nil
+}
+
+// bindRound rounds the number to the given decimals, 0 by default,
+// keeping the trailing zeros.
+func bindRound(args []string) (Func, error) {
+	decimals := 0
+	if len(args) > 0 {
+		var err error
+		if decimals, err = parseNonNegativeInt(args[0]); err != nil {
+			return nil, err
+		}
+	}
+	pow := math.Pow(10, float64(decimals))
+
+	return func(val interface{}) (interface{}, error) {
+		f, ok := toFloat(val)
+		if !ok {
+			return nil, fmt.Errorf("round expects a number, found '%v'", val)
+		}
+
+		return strconv.FormatFloat(math.Round(f*pow)/pow, 'f', decimals, 64), nil
+	}, nil
+}
+
+// humanizeDuration formats the seconds like "1d 2h 3m 4s", leaving out
+// the zero units. Durations under a second are formatted like "250ms".
+func humanizeDuration(val interface{}) (interface{}, error) {
+	f, ok := toFloat(val)
+	if !ok {
+		return nil, fmt.Errorf("humanizeDuration expects seconds, found '%v'", val)
+	}
+
+	d := time.Duration(f * float64(time.Second))
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	if d < time.Second {
+		return sign + d.String(), nil
+	}
+
+	d = d.Round(time.Second)
+	var parts []string
+	for _, unit := range [...]struct {
+		name string
+		size time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	} {
+		if n := d / unit.size; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", n, unit.name))
+			d -= n * unit.size
+		}
+	}
+
+	return sign + strings.Join(parts, " "), nil
+}
+
+// bindFormatTime formats the time by the layout of the package time, in
+// the time zone if given, like "Pacific/Auckland" or "+05:30", or else in
+// UTC. Ints are nanoseconds since the epoch, like the time of the points.
+func bindFormatTime(args []string) (Func, error) {
+	layout, loc := args[0], time.UTC
+	if len(args) > 1 {
+		var err error
+		if loc, err = timezone.LoadLocation(args[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(val interface{}) (interface{}, error) {
+		var t time.Time
+		switch v := val.(type) {
+		case time.Time:
+			t = v
+		case int64:
+			t = time.Unix(0, v)
+		default:
+			return nil, fmt.Errorf("format expects a time, found '%v'", val)
+		}
+
+		return t.In(loc).Format(layout), nil
+	}, nil
+}
+
+// bindMap replaces the value by the mapping like "APAC=Asia Pacific,EU=Europe",
+// leaving the values not in it unchanged.
+func bindMap(args []string) (Func, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(args[0], ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected 'key=value' in the mapping, found '%s'", pair)
+		}
+		mapping[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return func(val interface{}) (interface{}, error) {
+		if mapped, ok := mapping[stringify(val)]; ok {
+			return mapped, nil
+		}
+
+		return val, nil
+	}, nil
+}
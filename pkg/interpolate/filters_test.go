@@ -0,0 +1,170 @@
+package interpolate
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestFilters(t *testing.T) {
+	pnt := getKapacitorPoint()
+	pnt.Time = time.Date(2024, 3, 1, 1, 30, 0, 0, time.UTC).UnixNano()
+	pnt.Tags["host"] = "web-01"
+	pnt.Tags["region"] = "APAC"
+	pnt.FieldsString["msg"] = "disk usage above threshold"
+	pnt.FieldsDouble["duration_s"] = 93784
+	pnt.FieldsDouble["short_s"] = 0.25
+	pnt.FieldsDouble["value"] = 3.14159
+
+	for _, tc := range [...]struct {
+		str      string
+		expected string
+	}{
+		{"{host | upper}", "WEB-01"},
+		{"{host | upper | lower}", "web-01"},
+		{"{msg | truncate 9}", "disk usag"},
+		{"{msg | truncate 80}", "disk usage above threshold"},
+		{"{duration_s | humanizeDuration}", "1d 2h 3m 4s"},
+		{"{short_s | humanizeDuration}", "250ms"},
+		{`{time | format "2006-01-02 15:04"}`, "2024-03-01 01:30"},
+		{`{time | format "2006-01-02 15:04" "Pacific/Auckland"}`, "2024-03-01 14:30"},
+		{`{time | format "2006-01-02 15:04" "+05:30"}`, "2024-03-01 07:00"},
+		{`{time | format "15:04" "UTC-3"}`, "22:30"},
+		{"{value | round 1}", "3.1"},
+		{"{value | round}", "3"},
+		{"{fieldIntPos | round 2}", "3.00"},
+		{"{value:%.3f | round 1}", "3.100"},
+		{`{region | map "APAC=Asia Pacific,EU=Europe"}`, "Asia Pacific"},
+		{`{tag | map "APAC=Asia Pacific,EU=Europe"}`, "tagValue"},
+		{`{notExisting | upper | "unknown"}`, "unknown"},
+		{`{notExisting | "unknown" | upper}`, "UNKNOWN"},
+		{"{notExisting | upper}", ""},
+		{"{value}", "3.14"},
+	} {
+		t.Run(fmt.Sprintf("Execute %s", tc.str), func(t *testing.T) {
+			tpl, err := compile(tc.str)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			actual, err := tpl.execute(pnt)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFilterCompileErrors(t *testing.T) {
+	for _, str := range [...]string{
+		"{host | nope}",
+		"{host | upper 1}",
+		"{msg | truncate}",
+		"{msg | truncate many}",
+		"{value | round -1}",
+		`{time | format "15:04" "Nowhere/Nothing"}`,
+		`{region | map "APAC"}`,
+		`{host | "a" "b"}`,
+		`{host | format "15:04}`,
+		"{host | }",
+	} {
+		t.Run(fmt.Sprintf("Compile %s", str), func(t *testing.T) {
+			if _, err := compile(str); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestFilterRuntimeError(t *testing.T) {
+	tpl, err := compile("a {fieldStr | round 1} b")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	actual, err := tpl.execute(getKapacitorPoint())
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+	if actual != "a  b" {
+		t.Errorf("expected %v, actual %v", "a  b", actual)
+	}
+}
+
+func TestFilterErrorLoggedOnce(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ch := make(chan *agent.Response, 3)
+	ip := newInterpolateHandler(&agent.Agent{Responses: ch})
+	init, _ := ip.Init(&agent.InitRequest{Options: []*agent.Option{
+		{Name: "string", Values: []*agent.OptionValue{{Type: agent.ValueType_STRING, Value: &agent.OptionValue_StringValue{StringValue: "{fieldStr | round 1}"}}}},
+		{Name: "toField", Values: []*agent.OptionValue{{Type: agent.ValueType_STRING, Value: &agent.OptionValue_StringValue{StringValue: "msg"}}}},
+	}})
+	if !init.Success {
+		t.Fatalf("unexpected init error %v", init.Error)
+	}
+
+	for i := 0; i < 3; i++ {
+		ip.Point(getKapacitorPoint())
+	}
+
+	if len(ch) != 3 {
+		t.Errorf("expected the points to be sent, actual %d", len(ch))
+	}
+	if actual := strings.Count(buf.String(), "\n"); actual != 1 {
+		t.Errorf("expected the error to be logged once, actual %d times", actual)
+	}
+}
+
+func TestRegisterFilter(t *testing.T) {
+	RegisterFilter("reverse", NoArgs(func(val interface{}) (interface{}, error) {
+		runes := []rune(stringify(val))
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes), nil
+	}))
+
+	tpl, err := compile("{fieldStr | reverse | upper}")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	actual, _ := tpl.execute(getKapacitorPoint())
+	if actual != strings.ToUpper("doog") {
+		t.Errorf("expected %v, actual %v", "DOOG", actual)
+	}
+}
+
+func TestFilterBoundOnce(t *testing.T) {
+	binds := 0
+	RegisterFilter("suffix", Filter{MinArgs: 1, MaxArgs: 1, Bind: func(args []string) (Func, error) {
+		binds++
+		suffix := args[0]
+		return func(val interface{}) (interface{}, error) {
+			return stringify(val) + suffix, nil
+		}, nil
+	}})
+
+	tpl, err := compile(`{fieldStr | suffix "!"}`)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if actual, _ := tpl.execute(getKapacitorPoint()); actual != "good!" {
+			t.Errorf("expected %v, actual %v", "good!", actual)
+		}
+	}
+	if binds != 1 {
+		t.Errorf("expected the arguments to be bound once, actual %d", binds)
+	}
+}
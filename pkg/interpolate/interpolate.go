@@ -4,23 +4,25 @@ import (
 	"log"
 	"os"
 	"strings"
-	"unicode"
 
 	"github.com/influxdata/kapacitor/udf/agent"
-
-	"pkg/utils"
 )
 
 type interpolateHandler struct {
 	toField     string
 	inputString string
+	template    *template
+
+	// The filters whose errors are logged already
+	logged map[string]bool
 
 	agent *agent.Agent
 }
 
 func newInterpolateHandler(agent *agent.Agent) *interpolateHandler {
 	return &interpolateHandler{
-		agent: agent,
+		agent:  agent,
+		logged: make(map[string]bool),
 	}
 }
 
@@ -58,7 +60,17 @@ func (ip *interpolateHandler) Init(r *agent.InitRequest) (*agent.InitResponse, e
 	if len(ip.inputString) == 0 || len(ip.toField) == 0 {
 		init.Success = false
 		init.Error = "must supply 'toField' and 'string'"
+		return init, nil
+	}
+
+	// Compile the string once, rather than on every point
+	tpl, err := compile(ip.inputString)
+	if err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
 	}
+	ip.template = tpl
 
 	return init, nil
 }
@@ -82,7 +94,10 @@ func (*interpolateHandler) BeginBatch(begin *agent.BeginBatch) error {
 
 func (ip *interpolateHandler) Point(p *agent.Point) error {
 	// Interpolate the string and save it to the 'FieldsString'
-	strInterplolated, _ := interplolateString(ip.inputString, p)
+	strInterplolated, err := ip.template.execute(p)
+	if err != nil {
+		ip.logError(err)
+	}
 
 	if p.FieldsString == nil {
 		p.FieldsString = make(map[string]string)
@@ -100,36 +115,19 @@ func (ip *interpolateHandler) Point(p *agent.Point) error {
 	return nil
 }
 
-func interplolateString(str string, p *agent.Point) (string, error) {
-	// To interpolate string like "Lower {lowerThresh} upper {upperThresh} within {withinSec}s"
-	// with the fields or tags defined in Kapacitor pointer
-
-	var sb strings.Builder
-	var keyName strings.Builder
-	isKeyName := false
-	for _, ch := range str {
-		if ch == '{' {
-			isKeyName = true
-			keyName.Reset()
-		} else if ch == '}' {
-			key := keyName.String()
-			val := utils.StringifyPointByKey(key, p)
-			sb.WriteString(val)
-
-			isKeyName = false
-			keyName.Reset()
-		} else {
-			if isKeyName {
-				if !unicode.IsSpace(ch) {
-					keyName.WriteRune(ch)
-				}
-			} else {
-				sb.WriteRune(ch)
-			}
-		}
+// logError logs the error of a filter once, as a field of the wrong type
+// would fail on every point.
+func (ip *interpolateHandler) logError(err error) {
+	fe, ok := err.(*filterError)
+	if !ok {
+		log.Println(err)
+		return
 	}
 
-	return sb.String(), nil
+	if !ip.logged[fe.source()] {
+		ip.logged[fe.source()] = true
+		log.Printf("%v, further errors of this filter are not logged", fe)
+	}
 }
 
 func (ip *interpolateHandler) EndBatch(end *agent.EndBatch) error {
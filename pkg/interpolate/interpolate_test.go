@@ -38,7 +38,11 @@ func TestInterpolateString(t *testing.T) {
 				"tag tagValue and bool field true"},
 	} {
 		t.Run(fmt.Sprintf("Interpolate string with Kapacitor point fields and tags"), func(t *testing.T) {
-			actual, _ := interplolateString(tc.strToInterpolate, tc.pntKapacitor)
+			tpl, err := compile(tc.strToInterpolate)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			actual, _ := tpl.execute(tc.pntKapacitor)
 			if !reflect.DeepEqual(tc.expected, actual) {
 				t.Errorf("expected %v, actual %v", tc.expected, actual)
 			}
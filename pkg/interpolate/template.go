@@ -0,0 +1,403 @@
+package interpolate
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+// template is a compiled string like "CPU {cpu:%.1f}% on {host|"unknown"}",
+// the literal text of which is kept apart from the placeholders, so that
+// a point is interpolated without scanning the string again.
+//
+// A placeholder is the key of a tag or field, or "time" for the time of
+// the point, optionally followed by a format after ':' and a pipeline of
+// stages after '|'. The format is either a verb of fmt like "%.1f" or
+// "%05d", or "humanbytes" for sizes like "1.5 MiB". Without a format,
+// floats have 2 decimals. A stage is either a registered filter with its
+// arguments, like `truncate 80`, or a quoted default like `"unknown"` for
+// when the point has no such key. The filters run in order before the
+// format. "{{" and "}}" are the literal braces.
+type template struct {
+	parts []part
+}
+
+// part is either literal text or a placeholder.
+type part struct {
+	literal string
+
+	isKey  bool
+	key    string
+	format string
+	stages []stage
+}
+
+// stage is either a filter bound to its arguments, or a default of a
+// placeholder.
+type stage struct {
+	name string
+	fn   Func
+
+	isDefault bool
+	def       string
+}
+
+var verbRegex = regexp.MustCompile(`^%[-+# 0]*\d*(?:\.\d+)?[vdfeEgGsqxXtbo]$`)
+
+// compile parses the string into a template, or tells where it's wrong.
+func compile(str string) (*template, error) {
+	tpl := &template{}
+	runes := []rune(str)
+
+	var literal strings.Builder
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '{':
+			if i+1 < len(runes) && runes[i+1] == '{' {
+				literal.WriteRune('{')
+				i++
+				continue
+			}
+
+			end, err := placeholderEnd(runes, i)
+			if err != nil {
+				return nil, err
+			}
+
+			p, err := parsePlaceholder(string(runes[i+1 : end]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid placeholder at position %d: %v", i, err)
+			}
+
+			if literal.Len() > 0 {
+				tpl.parts = append(tpl.parts, part{literal: literal.String()})
+				literal.Reset()
+			}
+			tpl.parts = append(tpl.parts, p)
+			i = end
+		case '}':
+			if i+1 < len(runes) && runes[i+1] == '}' {
+				literal.WriteRune('}')
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("unexpected '}' at position %d, write '}}' for a literal brace", i)
+		default:
+			literal.WriteRune(runes[i])
+		}
+	}
+
+	if literal.Len() > 0 {
+		tpl.parts = append(tpl.parts, part{literal: literal.String()})
+	}
+
+	return tpl, nil
+}
+
+// placeholderEnd returns the position of the '}' closing the placeholder
+// opened at the position start, skipping the quoted strings in it.
+func placeholderEnd(runes []rune, start int) (int, error) {
+	inQuote := false
+	for i := start + 1; i < len(runes); i++ {
+		switch {
+		case inQuote && runes[i] == '\\':
+			i++
+		case runes[i] == '"':
+			inQuote = !inQuote
+		case !inQuote && runes[i] == '{':
+			return 0, fmt.Errorf("unexpected '{' at position %d in the placeholder at position %d", i, start)
+		case !inQuote && runes[i] == '}':
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unclosed '{' at position %d, write '{{' for a literal brace", start)
+}
+
+// splitPipes splits the placeholder by the '|' outside of quotes.
+func splitPipes(s string) []string {
+	var res []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case inQuote && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			inQuote = !inQuote
+		case !inQuote && s[i] == '|':
+			res = append(res, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(res, s[start:])
+}
+
+func parsePlaceholder(s string) (part, error) {
+	stages := splitPipes(s)
+
+	p := part{isKey: true}
+	p.key = strings.TrimSpace(stages[0])
+	if i := strings.Index(p.key, ":"); i >= 0 {
+		p.key, p.format = strings.TrimSpace(p.key[:i]), strings.TrimSpace(p.key[i+1:])
+		if p.format != "humanbytes" && !verbRegex.MatchString(p.format) {
+			return part{}, fmt.Errorf("unknown format '%s'", p.format)
+		}
+	}
+	if len(p.key) == 0 {
+		return part{}, fmt.Errorf("missing key")
+	}
+
+	hasDefault := false
+	for _, str := range stages[1:] {
+		words, err := splitWords(str)
+		if err != nil {
+			return part{}, err
+		}
+		if len(words) == 0 {
+			return part{}, fmt.Errorf("empty stage after '|'")
+		}
+
+		if strings.HasPrefix(strings.TrimSpace(str), `"`) {
+			if len(words) > 1 || hasDefault {
+				return part{}, fmt.Errorf("expected a single default, found '%s'", strings.TrimSpace(str))
+			}
+			p.stages = append(p.stages, stage{isDefault: true, def: words[0]})
+			hasDefault = true
+			continue
+		}
+
+		st, err := parseFilter(words[0], words[1:])
+		if err != nil {
+			return part{}, err
+		}
+		p.stages = append(p.stages, st)
+	}
+
+	return p, nil
+}
+
+func parseFilter(name string, args []string) (stage, error) {
+	f, ok := lookupFilter(name)
+	if !ok {
+		return stage{}, fmt.Errorf("unknown filter '%s'", name)
+	}
+	if len(args) < f.MinArgs || (f.MaxArgs >= 0 && len(args) > f.MaxArgs) {
+		return stage{}, fmt.Errorf("wrong number of arguments for filter '%s': %d", name, len(args))
+	}
+
+	fn, err := f.Bind(args)
+	if err != nil {
+		return stage{}, fmt.Errorf("invalid arguments for filter '%s': %v", name, err)
+	}
+
+	return stage{name: name, fn: fn}, nil
+}
+
+// splitWords splits the stage by the spaces outside of quotes, unquoting
+// the quoted words.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ' ' || s[i] == '\t':
+			i++
+		case s[i] == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unclosed quote in '%s'", strings.TrimSpace(s))
+			}
+			word, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", s[i:j+1])
+			}
+			words = append(words, word)
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && s[j] != ' ' && s[j] != '\t' && s[j] != '"' {
+				j++
+			}
+			words = append(words, s[i:j])
+			i = j
+		}
+	}
+
+	return words, nil
+}
+
+// execute interpolates the point p. A missing key without a default is
+// left empty, and so is a placeholder whose filter fails, the first error
+// of which is returned along with the string.
+func (tpl *template) execute(p *agent.Point) (string, error) {
+	var sb strings.Builder
+	var firstErr error
+
+	for _, pt := range tpl.parts {
+		if !pt.isKey {
+			sb.WriteString(pt.literal)
+			continue
+		}
+
+		str, err := pt.execute(p)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		sb.WriteString(str)
+	}
+
+	return sb.String(), firstErr
+}
+
+func (pt part) execute(p *agent.Point) (string, error) {
+	val, ok := lookup(pt.key, p)
+
+	// The default is text, it isn't formatted
+	defaulted := false
+	for _, st := range pt.stages {
+		switch {
+		case st.isDefault:
+			if !ok {
+				val, ok, defaulted = st.def, true, true
+			}
+		case ok:
+			var err error
+			if val, err = st.fn(val); err != nil {
+				return "", &filterError{key: pt.key, filter: st.name, err: err}
+			}
+		}
+	}
+
+	switch {
+	case !ok:
+		return "", nil
+	case pt.format != "" && !defaulted:
+		return formatValue(pt.format, val), nil
+	default:
+		return stringify(val), nil
+	}
+}
+
+// filterError is the error of a filter of a placeholder on a point.
+type filterError struct {
+	key    string
+	filter string
+	err    error
+}
+
+func (e *filterError) Error() string {
+	return fmt.Sprintf("placeholder '%s': filter '%s': %v", e.key, e.filter, e.err)
+}
+
+// source tells the placeholder and filter of the error apart from its
+// value.
+func (e *filterError) source() string {
+	return e.key + "|" + e.filter
+}
+
+// lookup returns the value of the tag or field of the key, in the order
+// of utils.StringifyPointByKey, or else the time of the point for "time".
+func lookup(key string, p *agent.Point) (interface{}, bool) {
+	if val, ok := p.Tags[key]; ok {
+		return val, true
+	}
+	if val, ok := p.FieldsString[key]; ok {
+		return val, true
+	}
+	if val, ok := p.FieldsInt[key]; ok {
+		return val, true
+	}
+	if val, ok := p.FieldsDouble[key]; ok {
+		return val, true
+	}
+	if val, ok := p.FieldsBool[key]; ok {
+		return val, true
+	}
+	if key == "time" {
+		return time.Unix(0, p.Time).UTC(), true
+	}
+
+	return nil, false
+}
+
+// stringify formats the value like utils.StringifyPointByKey, with 2
+// decimals for floats.
+func stringify(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// formatValue formats the value by the format, converting numbers to the
+// type of the verb, e.g. an int for "%.1f" or a float for "%d".
+func formatValue(format string, val interface{}) string {
+	if format == "humanbytes" {
+		if f, ok := toFloat(val); ok {
+			return humanBytes(f)
+		}
+		return fmt.Sprint(val)
+	}
+
+	switch format[len(format)-1] {
+	case 'f', 'e', 'E', 'g', 'G':
+		if f, ok := toFloat(val); ok {
+			val = f
+		}
+	case 'd', 'x', 'X', 'o', 'b':
+		if f, ok := val.(float64); ok {
+			val = int64(math.Round(f))
+		}
+	}
+
+	return fmt.Sprintf(format, val)
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// humanBytes formats the size in bytes by the binary units, like
+// "512 B" or "1.5 KiB".
+func humanBytes(b float64) string {
+	const units = "KMGTPE"
+
+	if math.Abs(b) < 1024 {
+		return strconv.FormatFloat(b, 'f', -1, 64) + " B"
+	}
+
+	i := -1
+	for math.Abs(b) >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+
+	return strconv.FormatFloat(b, 'f', 1, 64) + " " + string(units[i]) + "iB"
+}
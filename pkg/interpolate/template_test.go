@@ -0,0 +1,61 @@
+package interpolate
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTemplateExecute(t *testing.T) {
+	pnt := getKapacitorPoint()
+	pnt.FieldsInt["bytes"] = 1572864
+	pnt.FieldsDouble["small"] = 512
+
+	for _, tc := range [...]struct {
+		str      string
+		expected string
+	}{
+		{"{fieldFloatPos:%.1f}", "0.1"},
+		{"{fieldIntPos:%.1f}", "3.0"},
+		{"{fieldFloatPosRound:%d}", "0"},
+		{"{fieldIntNeg:%05d}", "-0022"},
+		{"{fieldStr:%q}", `"good"`},
+		{"{bytes:humanbytes}", "1.5 MiB"},
+		{"{small:humanbytes}", "512 B"},
+		{`{notExisting|"unknown"}`, "unknown"},
+		{`{tag | "unknown"}`, "tagValue"},
+		{`{notExisting:%.1f|"n/a"}`, "n/a"},
+		{`{notExisting|"a}b"}`, "a}b"},
+		{"{{literal}} {tag}", "{literal} tagValue"},
+		{"{{{tag}}}", "{tagValue}"},
+		{"no placeholders", "no placeholders"},
+	} {
+		t.Run(fmt.Sprintf("Execute %s", tc.str), func(t *testing.T) {
+			tpl, err := compile(tc.str)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if actual, _ := tpl.execute(pnt); actual != tc.expected {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTemplateCompileErrors(t *testing.T) {
+	for _, str := range [...]string{
+		"start {tag end",
+		"start tag} end",
+		"start {} end",
+		"start {tag:%z} end",
+		"start {tag:bogus} end",
+		"start {tag|unknown} end",
+		`start {tag|"a"|"b"} end`,
+		"start {a{b} end",
+	} {
+		t.Run(fmt.Sprintf("Compile %s", str), func(t *testing.T) {
+			if _, err := compile(str); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package rollingmeanstddev
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+
+	"pkg/fieldstats"
+	"pkg/matchtime"
+)
+
+// rollingMeanStddev is the stream variant of calcmeanstddev. It keeps the
+// mean and stddev over a sliding window of points per group, rather than
+// over a batch, and sends them on each point or every N points.
+type rollingMeanStddev struct {
+	fieldstats.Options
+	ddof int64
+
+	size   int64 // number of points in the window
+	period int64 // or duration of the window
+	every  int64 // send the statistics every N points
+
+	groups map[string]*rollingGroup
+
+	agent *agent.Agent
+}
+
+// rollingGroup is the state of a group. Only the samples in the windows
+// are saved in snapshots, the accumulators are rebuilt from them.
+type rollingGroup struct {
+	Count   int64              `json:"count"`
+	Windows map[string]*window `json:"windows"`
+}
+
+// window is the samples of a field in the window, oldest first.
+type window struct {
+	Samples []sample `json:"samples"`
+
+	acc     fieldstats.Accumulator
+	removed int
+}
+
+type sample struct {
+	T int64   `json:"t"`
+	V float64 `json:"v"`
+}
+
+func newRollingMeanStddev(agent *agent.Agent) *rollingMeanStddev {
+	return &rollingMeanStddev{
+		agent:  agent,
+		groups: make(map[string]*rollingGroup),
+	}
+}
+
+// Return the InfoResponse. Describing the properties of this UDF agent.
+func (*rollingMeanStddev) Info() (*agent.InfoResponse, error) {
+	info := &agent.InfoResponse{
+		Wants:    agent.EdgeType_STREAM,
+		Provides: agent.EdgeType_STREAM,
+
+		Options: map[string]*agent.OptionInfo{
+			"timeFilter":  {ValueTypes: []agent.ValueType{agent.ValueType_STRING, agent.ValueType_STRING}},
+			"field":       {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"fieldFormat": {ValueTypes: []agent.ValueType{agent.ValueType_STRING}},
+			"ddof":        {ValueTypes: []agent.ValueType{agent.ValueType_INT}},
+			"size":        {ValueTypes: []agent.ValueType{agent.ValueType_INT}},
+			"period":      {ValueTypes: []agent.ValueType{agent.ValueType_DURATION}},
+			"every":       {ValueTypes: []agent.ValueType{agent.ValueType_INT}},
+		},
+	}
+
+	return info, nil
+}
+
+// Initialze the handler based of the provided options.
+func (rm *rollingMeanStddev) Init(r *agent.InitRequest) (*agent.InitResponse, error) {
+	init := &agent.InitResponse{
+		Success: true,
+		Error:   "",
+	}
+
+	rm.every = 1
+
+	for _, opt := range r.Options {
+		if rm.Parse(opt) {
+			continue
+		}
+
+		switch opt.Name {
+		case "ddof":
+			rm.ddof = opt.Values[0].Value.(*agent.OptionValue_IntValue).IntValue
+		case "size":
+			rm.size = opt.Values[0].Value.(*agent.OptionValue_IntValue).IntValue
+		case "period":
+			rm.period = opt.Values[0].Value.(*agent.OptionValue_DurationValue).DurationValue
+		case "every":
+			rm.every = opt.Values[0].Value.(*agent.OptionValue_IntValue).IntValue
+		}
+	}
+
+	if err := rm.Validate("rollingmeanstddev"); err != nil {
+		init.Success = false
+		init.Error = err.Error()
+		return init, nil
+	}
+
+	if (rm.size > 0) == (rm.period > 0) {
+		init.Success = false
+		init.Error = "must supply either a positive 'size' or 'period'"
+		return init, nil
+	}
+
+	if rm.every <= 0 || rm.ddof < 0 {
+		init.Success = false
+		init.Error = "'every' must be positive and 'ddof' must not be negative"
+	}
+
+	return init, nil
+}
+
+// Create a snapshot of the running state of the process.
+func (rm *rollingMeanStddev) Snapshot() (*agent.SnapshotResponse, error) {
+	data, err := json.Marshal(rm.groups)
+	if err != nil {
+		return nil, err
+	}
+
+	return &agent.SnapshotResponse{
+		Snapshot: data,
+	}, nil
+}
+
+// Restore a previous snapshot.
+func (rm *rollingMeanStddev) Restore(req *agent.RestoreRequest) (*agent.RestoreResponse, error) {
+	groups := make(map[string]*rollingGroup)
+	if len(req.Snapshot) > 0 {
+		if err := json.Unmarshal(req.Snapshot, &groups); err != nil {
+			return &agent.RestoreResponse{
+				Success: false,
+				Error:   "failed to restore snapshot: " + err.Error(),
+			}, nil
+		}
+	}
+
+	// A null group or window is dropped, like those never seen
+	for key, g := range groups {
+		if g == nil {
+			delete(groups, key)
+			continue
+		}
+		if g.Windows == nil {
+			g.Windows = make(map[string]*window)
+		}
+		for field, w := range g.Windows {
+			if w == nil {
+				delete(g.Windows, field)
+				continue
+			}
+			w.rebuild()
+		}
+	}
+	rm.groups = groups
+
+	return &agent.RestoreResponse{
+		Success: true,
+	}, nil
+}
+
+// A stream has no batches
+func (*rollingMeanStddev) BeginBatch(begin *agent.BeginBatch) error {
+	return nil
+}
+
+func (rm *rollingMeanStddev) Point(p *agent.Point) error {
+	// Only process data points that match time mask
+	dt, _ := rm.TimeZone.In(p.GetTime(), p)
+	if len(rm.TimeMask) > 0 && !matchtime.MatchTimeWithMask(rm.TimeMask, &dt) {
+		return nil
+	}
+
+	g, ok := rm.groups[p.GetGroup()]
+	if !ok {
+		g = &rollingGroup{Windows: make(map[string]*window)}
+		rm.groups[p.GetGroup()] = g
+	}
+
+	for field, val := range rm.Fields.Values(p) {
+		w, ok := g.Windows[field]
+		if !ok {
+			w = &window{}
+			g.Windows[field] = w
+		}
+		w.add(sample{T: p.GetTime(), V: val}, rm.size)
+	}
+
+	// A period window slides by the time of the group's points, whether
+	// its field shows up or not
+	if rm.period > 0 {
+		for field, w := range g.Windows {
+			w.slide(p.GetTime(), rm.period)
+			if len(w.Samples) == 0 {
+				delete(g.Windows, field)
+			}
+		}
+	}
+
+	g.Count++
+	if g.Count%rm.every != 0 {
+		return nil
+	}
+
+	// Send the statistics of the windows with the point's time and tags
+	out := &agent.Point{
+		Time:            p.GetTime(),
+		Name:            p.GetName(),
+		Database:        p.GetDatabase(),
+		RetentionPolicy: p.GetRetentionPolicy(),
+		Group:           p.GetGroup(),
+		Dimensions:      p.GetDimensions(),
+		Tags:            p.GetTags(),
+		FieldsDouble:    make(map[string]float64),
+		FieldsInt:       make(map[string]int64),
+	}
+
+	for field, w := range g.Windows {
+		if w.acc.Count() == 0 {
+			continue
+		}
+		out.FieldsDouble[fieldstats.OutputFieldName(rm.FieldFormat, field, "mean")] = w.acc.Mean()
+		out.FieldsInt[fieldstats.OutputFieldName(rm.FieldFormat, field, "count")] = w.acc.Count()
+		if sd, ok := w.acc.Stddev(rm.ddof); ok {
+			out.FieldsDouble[fieldstats.OutputFieldName(rm.FieldFormat, field, "stddev")] = sd
+		}
+	}
+
+	rm.agent.Responses <- &agent.Response{
+		Message: &agent.Response_Point{
+			Point: out,
+		},
+	}
+
+	return nil
+}
+
+// A stream has no batches
+func (*rollingMeanStddev) EndBatch(end *agent.EndBatch) error {
+	return nil
+}
+
+// Stop the handler gracefully.
+func (rm *rollingMeanStddev) Stop() {
+	close(rm.agent.Responses)
+}
+
+// add appends the sample, and slides the window by the number of samples
+// if it has a size.
+func (w *window) add(s sample, size int64) {
+	w.Samples = append(w.Samples, s)
+	w.acc.Add(s.V)
+
+	if size > 0 {
+		w.drop(len(w.Samples) - int(size))
+	}
+}
+
+// slide drops the samples of the window older than the period up to t.
+func (w *window) slide(t int64, period int64) {
+	n := 0
+	for n < len(w.Samples) && w.Samples[n].T <= t-period {
+		n++
+	}
+	w.drop(n)
+}
+
+// drop removes the n oldest samples.
+func (w *window) drop(n int) {
+	if n <= 0 {
+		return
+	}
+
+	for _, old := range w.Samples[:n] {
+		w.acc.Remove(old.V)
+	}
+	w.Samples = w.Samples[n:]
+	w.removed += n
+
+	// Rebuild the accumulator from time to time, so the error of the
+	// removals doesn't build up
+	if w.removed >= len(w.Samples) && w.removed > 0 {
+		w.rebuild()
+	}
+}
+
+func (w *window) rebuild() {
+	w.acc = fieldstats.Accumulator{}
+	w.removed = 0
+	for _, s := range w.Samples {
+		w.acc.Add(s.V)
+	}
+}
+
+// Start is the entry point to start the stream UDF
+func Start() {
+	a := agent.New(os.Stdin, os.Stdout)
+	h := newRollingMeanStddev(a)
+	a.Handler = h
+
+	log.Println("Starting agent 'rollingmeanstddev'")
+	a.Start()
+	err := a.Wait()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
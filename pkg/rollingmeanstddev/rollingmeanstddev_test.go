@@ -0,0 +1,183 @@
+package rollingmeanstddev
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestPoint(t *testing.T) {
+	second := int64(time.Second)
+
+	for _, tc := range [...]struct {
+		opts     []*agent.Option
+		expected []float64 // mean of group "a" sent per point
+	}{
+		{[]*agent.Option{intOption("size", 2)}, []float64{1, 1.5, 2.5, 3.5, 4.5}},
+		{[]*agent.Option{durationOption("period", 3*time.Second)}, []float64{1, 1.5, 2, 3, 4}},
+		{[]*agent.Option{intOption("size", 3), intOption("every", 2)}, []float64{1.5, 3}},
+	} {
+		t.Run(fmt.Sprintf("Rolling mean %v", tc.expected), func(t *testing.T) {
+			ch := make(chan *agent.Response, 10)
+			rm := newRollingMeanStddev(&agent.Agent{Responses: ch})
+			initRolling(t, rm, append(tc.opts, stringOption("field", "cpu"))...)
+
+			for i, v := range []float64{1, 2, 3, 4, 5} {
+				rm.Point(&agent.Point{Time: int64(i) * second, Group: "a", FieldsDouble: map[string]float64{"cpu": v}})
+				// Another group doesn't affect group "a"
+				rm.Point(&agent.Point{Time: int64(i) * second, Group: "b", FieldsDouble: map[string]float64{"cpu": 100}})
+			}
+
+			var actual []float64
+			for len(ch) > 0 {
+				p := (<-ch).Message.(*agent.Response_Point).Point
+				if p.Group == "a" {
+					actual = append(actual, p.FieldsDouble["mean"])
+				}
+			}
+
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPeriodSlidesAllFields(t *testing.T) {
+	ch := make(chan *agent.Response, 2)
+	rm := newRollingMeanStddev(&agent.Agent{Responses: ch})
+	initRolling(t, rm, stringOption("field", "cpu,mem"), durationOption("period", 10))
+
+	rm.Point(&agent.Point{Time: 0, FieldsDouble: map[string]float64{"cpu": 1, "mem": 100}})
+	rm.Point(&agent.Point{Time: 1000, FieldsDouble: map[string]float64{"cpu": 2}})
+
+	<-ch
+	p := (<-ch).Message.(*agent.Response_Point).Point
+	if _, ok := p.FieldsDouble["mem_mean"]; ok {
+		t.Errorf("expected the window of mem to slide out, actual %v %v", p.FieldsDouble, p.FieldsInt)
+	}
+	if p.FieldsDouble["cpu_mean"] != 2 || p.FieldsInt["cpu_count"] != 1 {
+		t.Errorf("expected cpu mean 2 of 1 point, actual %v %v", p.FieldsDouble, p.FieldsInt)
+	}
+	if _, ok := rm.groups[""].Windows["mem"]; ok {
+		t.Errorf("expected the empty window of mem to be dropped")
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	opts := []*agent.Option{stringOption("field", "cpu"), intOption("size", 3)}
+
+	ch := make(chan *agent.Response, 10)
+	rm := newRollingMeanStddev(&agent.Agent{Responses: ch})
+	initRolling(t, rm, opts...)
+	for _, v := range []float64{1, 2, 3, 4} {
+		rm.Point(&agent.Point{Group: "a", FieldsDouble: map[string]float64{"cpu": v}})
+	}
+
+	snapshot, err := rm.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// A restarted process carries on with the window [2, 3, 4]
+	restoredCh := make(chan *agent.Response, 1)
+	restored := newRollingMeanStddev(&agent.Agent{Responses: restoredCh})
+	initRolling(t, restored, opts...)
+	if r, _ := restored.Restore(&agent.RestoreRequest{Snapshot: snapshot.Snapshot}); !r.Success {
+		t.Fatalf("unexpected restore error %v", r.Error)
+	}
+	restored.Point(&agent.Point{Group: "a", FieldsDouble: map[string]float64{"cpu": 8}})
+
+	p := (<-restoredCh).Message.(*agent.Response_Point).Point
+	if p.FieldsDouble["mean"] != 5 || p.FieldsInt["count"] != 3 || math.Abs(p.FieldsDouble["stddev"]-math.Sqrt(14.0/3)) > 1e-12 {
+		t.Errorf("expected mean 5, count 3 and stddev %v, actual %v %v", math.Sqrt(14.0/3), p.FieldsDouble, p.FieldsInt)
+	}
+
+	if r, _ := restored.Restore(&agent.RestoreRequest{Snapshot: []byte("[")}); r.Success {
+		t.Errorf("expected restore of invalid snapshot to fail")
+	}
+}
+
+func TestRestoreNull(t *testing.T) {
+	for _, snapshot := range []string{
+		`{"a":null}`,
+		`{"a":{"windows":null}}`,
+		`{"a":{"windows":{"cpu":null}}}`,
+	} {
+		t.Run(fmt.Sprintf("Restore %s", snapshot), func(t *testing.T) {
+			rm := newRollingMeanStddev(&agent.Agent{Responses: make(chan *agent.Response, 1)})
+			initRolling(t, rm, stringOption("field", "cpu"), intOption("size", 3))
+			if r, _ := rm.Restore(&agent.RestoreRequest{Snapshot: []byte(snapshot)}); !r.Success {
+				t.Fatalf("unexpected restore failure %v", r.Error)
+			}
+
+			if err := rm.Point(&agent.Point{Group: "a", FieldsDouble: map[string]float64{"cpu": 1}}); err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestInitInvalid(t *testing.T) {
+	for _, opts := range [][]*agent.Option{
+		{stringOption("field", "cpu")},
+		{stringOption("field", "cpu"), intOption("size", 3), durationOption("period", time.Minute)},
+		{stringOption("field", "cpu"), intOption("size", 3), intOption("every", 0)},
+		{intOption("size", 3)},
+	} {
+		rm := newRollingMeanStddev(&agent.Agent{})
+		if init, _ := rm.Init(newInitRequest(opts...)); init.Success {
+			t.Errorf("expected failure of %v, actual success", opts)
+		}
+	}
+}
+
+func initRolling(t *testing.T, rm *rollingMeanStddev, opts ...*agent.Option) {
+	t.Helper()
+
+	init, _ := rm.Init(newInitRequest(opts...))
+	if !init.Success {
+		t.Fatalf("unexpected init error %v", init.Error)
+	}
+}
+
+func intOption(name string, value int64) *agent.Option {
+	return &agent.Option{
+		Name: name,
+		Values: []*agent.OptionValue{
+			{Type: agent.ValueType_INT, Value: &agent.OptionValue_IntValue{IntValue: value}},
+		},
+	}
+}
+
+func durationOption(name string, value time.Duration) *agent.Option {
+	return &agent.Option{
+		Name: name,
+		Values: []*agent.OptionValue{
+			{Type: agent.ValueType_DURATION, Value: &agent.OptionValue_DurationValue{DurationValue: int64(value)}},
+		},
+	}
+}
+
+func newInitRequest(opts ...*agent.Option) *agent.InitRequest {
+	r := &agent.InitRequest{}
+	for _, opt := range opts {
+		if opt != nil {
+			r.Options = append(r.Options, opt)
+		}
+	}
+
+	return r
+}
+
+func stringOption(name, value string) *agent.Option {
+	return &agent.Option{
+		Name: name,
+		Values: []*agent.OptionValue{
+			{Type: agent.ValueType_STRING, Value: &agent.OptionValue_StringValue{StringValue: value}},
+		},
+	}
+}
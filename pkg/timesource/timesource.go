@@ -0,0 +1,246 @@
+package timesource
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+// Policy is what to do with a point whose event time cannot be read.
+type Policy int
+
+const (
+	// PolicyFallback uses the point time instead.
+	PolicyFallback Policy = iota
+	// PolicyDrop discards the point.
+	PolicyDrop
+	// PolicyFail stops the handler with an error.
+	PolicyFail
+)
+
+// ParsePolicy converts "fallback", "drop" or "fail" to a Policy.
+func ParsePolicy(policy string) (Policy, error) {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case "", "fallback":
+		return PolicyFallback, nil
+	case "drop":
+		return PolicyDrop, nil
+	case "fail":
+		return PolicyFail, nil
+	}
+
+	return PolicyFallback, fmt.Errorf("invalid time source policy '%s', must be 'fallback', 'drop' or 'fail'", policy)
+}
+
+// Epoch units and the layouts tried when the format is "auto"
+var (
+	units = map[string]int64{
+		"s":  int64(time.Second),
+		"ms": int64(time.Millisecond),
+		"us": int64(time.Microsecond),
+		"ns": 1,
+	}
+
+	autoLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05",
+	}
+)
+
+// Source reads the event time of a point from one of its tags or fields,
+// e.g. when devices buffer data and the point time is the ingestion time.
+// A nil Source or one without key uses the point time.
+type Source struct {
+	key    string
+	format string
+	policy Policy
+}
+
+// NewSource creates the source reading the time from the tag or field 'key'.
+// The format could be "auto", an epoch unit "s", "ms", "us" or "ns",
+// "rfc3339", or a Go time layout like "2006-01-02 15:04:05". With "auto",
+// the unit of an epoch is detected from its magnitude and strings are
+// tried as epochs and then as RFC3339 and similar layouts. Times of
+// layouts without a zone are in the location given to Time.
+func NewSource(key string, format string, policy Policy) (*Source, error) {
+	format = strings.TrimSpace(format)
+	switch strings.ToLower(format) {
+	case "", "auto":
+		format = "auto"
+	case "rfc3339":
+		format = time.RFC3339Nano
+	case "s", "ms", "us", "ns":
+		format = strings.ToLower(format)
+	default:
+		if !strings.ContainsAny(format, "0123456789") {
+			return nil, fmt.Errorf("invalid time source format '%s'", format)
+		}
+	}
+
+	return &Source{
+		key:    strings.TrimSpace(key),
+		format: format,
+		policy: policy,
+	}, nil
+}
+
+// Policy returns the policy for points whose event time cannot be read.
+func (s *Source) Policy() Policy {
+	if s == nil {
+		return PolicyFallback
+	}
+
+	return s.policy
+}
+
+// Time returns the nanosecond epoch event time of the point p. A time
+// without a zone is in the location loc, usually the time zone of the
+// point, and cannot be read if loc is nil. If the time cannot be read, it
+// returns the point time under PolicyFallback, or an error otherwise so
+// that the caller can apply the policy.
+func (s *Source) Time(p *agent.Point, loc *time.Location) (int64, error) {
+	if s == nil || len(s.key) == 0 {
+		return p.GetTime(), nil
+	}
+
+	t, err := s.read(p, loc)
+	if err != nil {
+		if s.policy == PolicyFallback {
+			return p.GetTime(), nil
+		}
+		return 0, err
+	}
+
+	return t, nil
+}
+
+func (s *Source) read(p *agent.Point, loc *time.Location) (int64, error) {
+	if val, ok := p.Tags[s.key]; ok {
+		return s.parseString(val, loc)
+	}
+	if val, ok := p.FieldsString[s.key]; ok {
+		return s.parseString(val, loc)
+	}
+	if val, ok := p.FieldsInt[s.key]; ok {
+		return s.parseEpochInt(val)
+	}
+	if val, ok := p.FieldsDouble[s.key]; ok {
+		return s.parseEpoch(val)
+	}
+
+	return 0, fmt.Errorf("missing time source '%s'", s.key)
+}
+
+func (s *Source) parseString(val string, loc *time.Location) (int64, error) {
+	val = strings.TrimSpace(val)
+
+	if s.format == "auto" || units[s.format] > 0 {
+		if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return s.parseEpochInt(i)
+		}
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return s.parseEpoch(f)
+		}
+	}
+
+	layouts := []string{s.format}
+	if s.format == "auto" {
+		layouts = autoLayouts
+	}
+
+	for _, layout := range layouts {
+		if !hasZone(layout) {
+			if loc == nil {
+				continue
+			}
+			if t, err := time.ParseInLocation(layout, val, loc); err == nil {
+				return t.UnixNano(), nil
+			}
+		} else if t, err := time.Parse(layout, val); err == nil {
+			return t.UnixNano(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid time '%s' in time source '%s'", val, s.key)
+}
+
+// hasZone tells if the layout reads the zone of the time, by its offset
+// like "Z07:00" or "-0700" or by its abbreviation "MST".
+func hasZone(layout string) bool {
+	return strings.Contains(layout, "Z07") || strings.Contains(layout, "-07") || strings.Contains(layout, "MST")
+}
+
+func (s *Source) parseEpochInt(val int64) (int64, error) {
+	unit, err := s.unit(float64(val))
+	if err != nil {
+		return 0, err
+	}
+
+	if val > math.MaxInt64/unit || val < math.MinInt64/unit {
+		return 0, s.errOutOfRange(val)
+	}
+
+	return val * unit, nil
+}
+
+func (s *Source) parseEpoch(val float64) (int64, error) {
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return 0, fmt.Errorf("invalid time %v in time source '%s'", val, s.key)
+	}
+
+	unit, err := s.unit(val)
+	if err != nil {
+		return 0, err
+	}
+
+	// The float of math.MaxInt64 rounds up to 2^63, which is out of range
+	t := val * float64(unit)
+	if t >= math.MaxInt64 || t < math.MinInt64 {
+		return 0, s.errOutOfRange(val)
+	}
+
+	return int64(t), nil
+}
+
+// errOutOfRange is the error of a time beyond the nanosecond times of
+// int64, which end in 2262.
+func (s *Source) errOutOfRange(val interface{}) error {
+	return fmt.Errorf("time %v in time source '%s' is out of range", val, s.key)
+}
+
+func (s *Source) unit(val float64) (int64, error) {
+	if unit, ok := units[s.format]; ok {
+		return unit, nil
+	}
+
+	if s.format != "auto" {
+		return 0, fmt.Errorf("numeric time %v in time source '%s' does not match format '%s'", val, s.key, s.format)
+	}
+
+	return detectUnit(val), nil
+}
+
+func detectUnit(val float64) int64 {
+	// Guess the unit from the magnitude of the epoch, e.g. the epoch of
+	// 2019-08-26 is around 1.5e9 in seconds and 1.5e12 in milliseconds.
+	// It works for times between 1973 and 2262, where the nanosecond
+	// times of int64 end. Later times are out of range in any unit.
+
+	v := math.Abs(val)
+	switch {
+	case v < 1e11:
+		return units["s"]
+	case v < 1e14:
+		return units["ms"]
+	case v < 1e17:
+		return units["us"]
+	}
+
+	return units["ns"]
+}
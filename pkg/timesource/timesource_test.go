@@ -0,0 +1,121 @@
+package timesource
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestSourceTime(t *testing.T) {
+	dt, _ := time.Parse(time.RFC3339, "2019-08-26T03:15:15Z")
+	pointTime := dt.Add(time.Hour).UnixNano()
+	nz, _ := time.LoadLocation("Pacific/Auckland")
+
+	for _, tc := range [...]struct {
+		format   string
+		policy   Policy
+		loc      *time.Location
+		pntKap   *agent.Point
+		expected int64
+		isErr    bool
+	}{
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, int64(1566789315), nil), dt.UnixNano(), false},
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, int64(1566789315000), nil), dt.UnixNano(), false},
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, int64(1566789315000000), nil), dt.UnixNano(), false},
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, dt.UnixNano(), nil), dt.UnixNano(), false},
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, 1566789315.5, nil), dt.UnixNano() + int64(500*time.Millisecond), false},
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, "1566789315000", nil), dt.UnixNano(), false},
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, "2019-08-26T15:15:15+12:00", nil), dt.UnixNano(), false},
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, "2019-08-26 03:15:15", nil), dt.UnixNano(), false},
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, nil, map[string]string{"event_ts": "2019-08-26T03:15:15Z"}), dt.UnixNano(), false},
+		{"ms", PolicyFallback, time.UTC, newPoint(pointTime, int64(1566789315000), nil), dt.UnixNano(), false},
+		{"s", PolicyFallback, time.UTC, newPoint(pointTime, "1566789315", nil), dt.UnixNano(), false},
+		{"rfc3339", PolicyFallback, time.UTC, newPoint(pointTime, "2019-08-26T03:15:15Z", nil), dt.UnixNano(), false},
+		{"02/01/2006 15:04:05", PolicyFallback, time.UTC, newPoint(pointTime, "26/08/2019 03:15:15", nil), dt.UnixNano(), false},
+		// Times without a zone are in the given location, if any
+		{"auto", PolicyFallback, nz, newPoint(pointTime, "2019-08-26 15:15:15", nil), dt.UnixNano(), false},
+		{"auto", PolicyFallback, nz, newPoint(pointTime, "2019-08-26T15:15:15+12:00", nil), dt.UnixNano(), false},
+		{"02/01/2006 15:04:05", PolicyFallback, nz, newPoint(pointTime, "26/08/2019 15:15:15", nil), dt.UnixNano(), false},
+		{"auto", PolicyFallback, nil, newPoint(pointTime, "2019-08-26T15:15:15+12:00", nil), dt.UnixNano(), false},
+		{"auto", PolicyFallback, nil, newPoint(pointTime, "2019-08-26 03:15:15", nil), pointTime, false},
+		{"auto", PolicyDrop, nil, newPoint(pointTime, "2019-08-26 03:15:15", nil), 0, true},
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, "yesterday", nil), pointTime, false},
+		{"auto", PolicyFallback, time.UTC, newPoint(pointTime, nil, nil), pointTime, false},
+		{"rfc3339", PolicyFallback, time.UTC, newPoint(pointTime, int64(1566789315), nil), pointTime, false},
+		{"auto", PolicyDrop, time.UTC, newPoint(pointTime, "yesterday", nil), 0, true},
+		{"auto", PolicyFail, time.UTC, newPoint(pointTime, nil, nil), 0, true},
+		// Times after 2262 overflow the nanoseconds of int64
+		{"auto", PolicyDrop, time.UTC, newPoint(pointTime, int64(1e10), nil), 0, true},
+		{"auto", PolicyDrop, time.UTC, newPoint(pointTime, 5e10, nil), 0, true},
+		{"auto", PolicyDrop, time.UTC, newPoint(pointTime, int64(1e13), nil), 0, true},
+		{"s", PolicyDrop, time.UTC, newPoint(pointTime, "-10000000000", nil), 0, true},
+	} {
+		t.Run(fmt.Sprintf("Read time with format '%s'", tc.format), func(t *testing.T) {
+			s, err := NewSource("event_ts", tc.format, tc.policy)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			actual, err := s.Time(tc.pntKap, tc.loc)
+			if (err != nil) != tc.isErr {
+				t.Fatalf("expected error %v, actual %v", tc.isErr, err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %v, actual %v", time.Unix(0, tc.expected).UTC(), time.Unix(0, actual).UTC())
+			}
+		})
+	}
+}
+
+func TestNilSourceTime(t *testing.T) {
+	var s *Source
+	p := newPoint(100, int64(1566789315), nil)
+
+	if actual, _ := s.Time(p, time.UTC); actual != 100 {
+		t.Errorf("expected %v, actual %v", 100, actual)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, tc := range [...]struct {
+		policy   string
+		expected Policy
+		isErr    bool
+	}{
+		{"", PolicyFallback, false},
+		{"fallback", PolicyFallback, false},
+		{"Drop", PolicyDrop, false},
+		{"fail", PolicyFail, false},
+		{"ignore", PolicyFallback, true},
+	} {
+		t.Run(fmt.Sprintf("Parse policy '%s'", tc.policy), func(t *testing.T) {
+			actual, err := ParsePolicy(tc.policy)
+			if actual != tc.expected || (err != nil) != tc.isErr {
+				t.Errorf("expected %v (error %v), actual %v (error %v)", tc.expected, tc.isErr, actual, err)
+			}
+		})
+	}
+}
+
+func newPoint(t int64, eventTime interface{}, tags map[string]string) *agent.Point {
+	p := &agent.Point{
+		Time:         t,
+		Tags:         tags,
+		FieldsInt:    map[string]int64{},
+		FieldsDouble: map[string]float64{},
+		FieldsString: map[string]string{},
+	}
+
+	switch v := eventTime.(type) {
+	case int64:
+		p.FieldsInt["event_ts"] = v
+	case float64:
+		p.FieldsDouble["event_ts"] = v
+	case string:
+		p.FieldsString["event_ts"] = v
+	}
+
+	return p
+}
@@ -0,0 +1,237 @@
+package timezone
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Embed the IANA time zone database so the UDF binaries do not
+	// depend on zoneinfo being installed in the container.
+	_ "time/tzdata"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+
+	"pkg/utils"
+)
+
+// Policy is what to do with a point whose per-point time zone
+// (e.g. "{tz}") cannot be resolved.
+type Policy int
+
+const (
+	// PolicyDefault evaluates the point in the default time zone.
+	PolicyDefault Policy = iota
+	// PolicyDrop discards the point.
+	PolicyDrop
+	// PolicyPassthrough lets the point bypass the time mask.
+	PolicyPassthrough
+)
+
+// ErrUnknownTimeZone is returned when the time zone of a point cannot
+// be resolved and the policy is not PolicyDefault.
+var ErrUnknownTimeZone = errors.New("unknown time zone")
+
+// ParsePolicy converts "default", "drop" or "passthrough" to a Policy.
+func ParsePolicy(policy string) (Policy, error) {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case "", "default":
+		return PolicyDefault, nil
+	case "drop":
+		return PolicyDrop, nil
+	case "passthrough":
+		return PolicyPassthrough, nil
+	}
+
+	return PolicyDefault, fmt.Errorf("invalid time zone policy '%s', must be 'default', 'drop' or 'passthrough'", policy)
+}
+
+// maxCached caps the cache, as per-point zones come from the data
+// and could be anything.
+const maxCached = 1024
+
+var (
+	// Cached locations by name, nil for an unknown name
+	cacheLock sync.RWMutex
+	cache     = make(map[string]*time.Location)
+
+	// Fixed offsets like "+05:30", "-0300", "UTC-3" or "GMT+5:30"
+	fixedOffsetRe = regexp.MustCompile(`^(?:UTC|GMT)?\s*([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+	// Per-point zones like "{timezone}"
+	keyRe = regexp.MustCompile(`^\{(\S+)\}$`)
+)
+
+// LoadLocation returns the location with the given name. The name could be
+// a "TZ database name" (e.g. Pacific/Auckland) from
+// https://en.wikipedia.org/wiki/List_of_tz_database_time_zones
+// or a fixed offset like "+05:30" or "UTC-3". An empty name is the local
+// time zone of the process, as it always was for an unset zone.
+// Locations are cached, so the zoneinfo is only read once per name.
+func LoadLocation(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+
+	cacheLock.RLock()
+	loc, ok := cache[name]
+	cacheLock.RUnlock()
+	if ok {
+		if loc == nil {
+			return nil, fmt.Errorf("%w '%s'", ErrUnknownTimeZone, name)
+		}
+		return loc, nil
+	}
+
+	loc, err := loadLocation(name)
+
+	cacheLock.Lock()
+	if len(cache) < maxCached {
+		cache[name] = loc
+	}
+	cacheLock.Unlock()
+
+	return loc, err
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if len(name) == 0 {
+		return time.Local, nil
+	}
+
+	if match := fixedOffsetRe.FindStringSubmatch(name); match != nil {
+		return parseFixedOffset(name, match)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownTimeZone, name)
+	}
+
+	return loc, nil
+}
+
+func parseFixedOffset(name string, match []string) (*time.Location, error) {
+	h, _ := strconv.Atoi(match[2])
+	m := 0
+	if len(match[3]) > 0 {
+		m, _ = strconv.Atoi(match[3])
+	}
+
+	if h > 14 || m > 59 {
+		return nil, fmt.Errorf("%w '%s', offset out of range", ErrUnknownTimeZone, name)
+	}
+
+	offset := h*3600 + m*60
+	if match[1] == "-" {
+		offset = -offset
+	}
+
+	return time.FixedZone(fmt.Sprintf("UTC%s%02d:%02d", match[1], h, m), offset), nil
+}
+
+// Zone is the time zone configured for a handler. It's either a static
+// zone like "Pacific/Auckland", or a "{key}" reference to the tag or
+// field of each point which holds the zone name.
+type Zone struct {
+	key      string
+	location *time.Location
+
+	defaultLocation *time.Location
+	policy          Policy
+}
+
+// NewZone creates the zone from the time zone option, e.g. "Pacific/Auckland"
+// or "{timezone}". The default zone is used for points whose own zone cannot
+// be resolved under PolicyDefault. An empty zone or default zone is the
+// local time zone. Static zones are validated here rather than silently
+// falling back to the local time zone for every point.
+func NewZone(zone string, defaultZone string, policy Policy) (*Zone, error) {
+	defaultLoc, err := LoadLocation(defaultZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default time zone: %w", err)
+	}
+
+	z := &Zone{
+		defaultLocation: defaultLoc,
+		policy:          policy,
+	}
+
+	if key := parseKey(zone); len(key) > 0 {
+		z.key = key
+		return z, nil
+	}
+
+	if z.location, err = LoadLocation(zone); err != nil {
+		return nil, fmt.Errorf("invalid time zone: %w", err)
+	}
+
+	return z, nil
+}
+
+// Policy returns the policy for points whose time zone cannot be resolved.
+func (z *Zone) Policy() Policy {
+	return z.policy
+}
+
+// IsPerPoint tells if the zone is read from each point.
+func (z *Zone) IsPerPoint() bool {
+	return len(z.key) > 0
+}
+
+// Location returns the static location of the zone, or the default
+// location if the zone is read from each point.
+func (z *Zone) Location() *time.Location {
+	if z.location != nil {
+		return z.location
+	}
+
+	return z.defaultLocation
+}
+
+// Resolve returns the location of the point p. If the point's zone cannot
+// be resolved, it returns the default location under PolicyDefault, or
+// ErrUnknownTimeZone otherwise so that the caller can apply the policy.
+func (z *Zone) Resolve(p *agent.Point) (*time.Location, error) {
+	if z.location != nil {
+		return z.location, nil
+	}
+
+	name := utils.StringifyPointByKey(z.key, p)
+	if len(name) > 0 {
+		if loc, err := LoadLocation(name); err == nil {
+			return loc, nil
+		}
+	}
+
+	if z.policy == PolicyDefault {
+		return z.defaultLocation, nil
+	}
+
+	return nil, ErrUnknownTimeZone
+}
+
+// In converts the nanosecond epoch time t to the time zone of the point p.
+func (z *Zone) In(t int64, p *agent.Point) (time.Time, error) {
+	loc, err := z.Resolve(p)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, t).In(loc), nil
+}
+
+func parseKey(zone string) string {
+	// Extract the key from the zone like "{timezone}"
+	if !strings.HasPrefix(zone, "{") {
+		return ""
+	}
+
+	match := keyRe.FindStringSubmatch(zone)
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
@@ -0,0 +1,142 @@
+package timezone
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/udf/agent"
+)
+
+func TestParseKey(t *testing.T) {
+	for _, tc := range [...]struct {
+		timezone string
+		expected string
+	}{
+		{"Pacific/Auckland", ""},
+		{"{timezone}", "timezone"},
+		{"", ""},
+		{"{}", ""},
+		{"{time zone}", ""},
+	} {
+		t.Run(fmt.Sprintf("Parse key of time zone '%s'", tc.timezone), func(t *testing.T) {
+			actual := parseKey(tc.timezone)
+			if tc.expected != actual {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	dt, _ := time.Parse(time.RFC3339, "2019-08-26T03:15:15Z")
+
+	for _, tc := range [...]struct {
+		name     string
+		expected string
+		isErr    bool
+	}{
+		{"", dt.In(time.Local).Format(time.RFC3339), false},
+		{"UTC", "2019-08-26T03:15:15Z", false},
+		{"Pacific/Auckland", "2019-08-26T15:15:15+12:00", false},
+		{"+05:30", "2019-08-26T08:45:15+05:30", false},
+		{"-0300", "2019-08-26T00:15:15-03:00", false},
+		{"UTC-3", "2019-08-26T00:15:15-03:00", false},
+		{"GMT+5:30", "2019-08-26T08:45:15+05:30", false},
+		{"+15:00", "", true},
+		{"+05:75", "", true},
+		{"NotExisting", "", true},
+		{"NotExisting", "", true}, // cached as unknown
+	} {
+		t.Run(fmt.Sprintf("Load location '%s'", tc.name), func(t *testing.T) {
+			loc, err := LoadLocation(tc.name)
+			if tc.isErr {
+				if !errors.Is(err, ErrUnknownTimeZone) {
+					t.Errorf("expected error %v, actual %v", ErrUnknownTimeZone, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if actual := dt.In(loc).Format(time.RFC3339); actual != tc.expected {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNewZone(t *testing.T) {
+	for _, tc := range [...]struct {
+		zone        string
+		defaultZone string
+		isErr       bool
+	}{
+		{"Pacific/Auckland", "", false},
+		{"{timezone}", "", false},
+		{"{timezone}", "Europe/Paris", false},
+		{"", "", false},
+		{"NotExisting", "", true},
+		{"{}", "", true},
+		{"{timezone}", "NotExisting", true},
+	} {
+		t.Run(fmt.Sprintf("New zone '%s'", tc.zone), func(t *testing.T) {
+			_, err := NewZone(tc.zone, tc.defaultZone, PolicyDefault)
+			if (err != nil) != tc.isErr {
+				t.Errorf("expected error %v, actual %v", tc.isErr, err)
+			}
+		})
+	}
+}
+
+func TestZoneIn(t *testing.T) {
+	dtUTC, _ := time.Parse(time.RFC3339, "2019-08-26T03:15:15Z")
+	dtUTC1, _ := time.Parse(time.RFC3339, "2019-10-26T02:15:15Z")
+
+	nz := &agent.Point{Tags: map[string]string{"timezone": "Pacific/Auckland"}}
+	unknown := &agent.Point{Tags: map[string]string{"timezone": "NotExisting"}}
+	missing := &agent.Point{}
+
+	// An unset zone is the local time zone
+	local := dtUTC.In(time.Local).Format(time.RFC3339)
+
+	for _, tc := range [...]struct {
+		zone        string
+		defaultZone string
+		policy      Policy
+		dt          time.Time
+		pnt         *agent.Point
+		expected    string
+		isErr       bool
+	}{
+		{"", "", PolicyDefault, dtUTC, missing, local, false},
+		{"Pacific/Auckland", "", PolicyDrop, dtUTC, missing, "2019-08-26T15:15:15+12:00", false},
+		{"Pacific/Auckland", "", PolicyDefault, dtUTC1, missing, "2019-10-26T15:15:15+13:00", false},
+		{"{timezone}", "", PolicyDrop, dtUTC, nz, "2019-08-26T15:15:15+12:00", false},
+		{"{timezone}", "", PolicyDefault, dtUTC, unknown, local, false},
+		{"{timezone}", "+05:30", PolicyDefault, dtUTC, missing, "2019-08-26T08:45:15+05:30", false},
+		{"{timezone}", "", PolicyDrop, dtUTC, unknown, "", true},
+		{"{timezone}", "", PolicyPassthrough, dtUTC, missing, "", true},
+	} {
+		t.Run(fmt.Sprintf("Convert time to zone '%s'", tc.zone), func(t *testing.T) {
+			z, err := NewZone(tc.zone, tc.defaultZone, tc.policy)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			actual, err := z.In(tc.dt.UnixNano(), tc.pnt)
+			if tc.isErr {
+				if err != ErrUnknownTimeZone {
+					t.Errorf("expected error %v, actual %v", ErrUnknownTimeZone, err)
+				}
+				return
+			}
+
+			if actual.Format(time.RFC3339) != tc.expected {
+				t.Errorf("expected %v, actual %v", tc.expected, actual.Format(time.RFC3339))
+			}
+		})
+	}
+}
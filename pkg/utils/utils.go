@@ -2,6 +2,7 @@ package utils
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/influxdata/kapacitor/udf/agent"
 )
@@ -28,3 +29,16 @@ func StringifyPointByKey(key string, p *agent.Point) string {
 
 	return ""
 }
+
+// SplitList is to split a comma-separated list like "mean, stddev"
+// into its trimmed, non-empty items.
+func SplitList(list string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -38,6 +38,25 @@ func TestStringifyPointByKey(t *testing.T) {
 	}
 }
 
+func TestSplitList(t *testing.T) {
+	for _, tc := range [...]struct {
+		list     string
+		expected []string
+	}{
+		{"mean,stddev", []string{"mean", "stddev"}},
+		{" mean , p90 ,, ", []string{"mean", "p90"}},
+		{"mean", []string{"mean"}},
+		{"", []string{}},
+	} {
+		t.Run(fmt.Sprintf("Split list '%s'", tc.list), func(t *testing.T) {
+			actual := SplitList(tc.list)
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v, actual %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
 func getKapacitorPoint() *agent.Point {
 	return &agent.Point{
 		FieldsInt: map[string]int64{